@@ -2,8 +2,10 @@ package main
 
 import (
 	"bytes"
+	"flag"
 	"fmt"
 	"math/rand"
+	"os"
 	"strconv"
 	"time"
 
@@ -22,6 +24,7 @@ type grid struct {
 	size                int
 	connectedEastWest   bool
 	connectedNorthSouth bool
+	rule                rule
 	population          [][]cell
 }
 
@@ -29,14 +32,16 @@ var population float32
 var size int
 var ew bool
 var ns bool
+var lifeRule rule
 var g grid
 var running bool
 
 // Initialize the grid
-func (g *grid) initialize(population float32, size int, ew bool, ns bool) {
+func (g *grid) initialize(population float32, size int, ew bool, ns bool, r rule) {
 	g.size = size
 	g.connectedEastWest = ew
 	g.connectedNorthSouth = ns
+	g.rule = r
 	g.population = nil
 	id := 0
 	for x := 0; x < size; x++ {
@@ -129,45 +134,31 @@ func (g grid) countAliveNeighbours(c *cell) {
 		}
 	}
 	c._neighbours = alive
-	//c.peekEvolve()
+	//c.peekEvolve(g.rule)
 }
 
-func (c cell) peekEvolve() {
+func (c cell) peekEvolve(r rule) {
 	if c.alive {
-		if c._neighbours < 2 {
-			fmt.Printf("%d will DIE by UNDERPOPULATION\n", c._id)
-			return
-		}
-		if c._neighbours > 3 {
-			fmt.Printf("%d will DIE by OVERPOPULATION\n", c._id)
+		if !r.next(true, c._neighbours) {
+			fmt.Printf("%d will DIE with %d neighbours\n", c._id, c._neighbours)
 			return
 		}
 		fmt.Printf("%d will STAY ALIVE\n", c._id)
 	} else {
-		if c._neighbours == 3 {
+		if r.next(false, c._neighbours) {
 			fmt.Printf("%d WILL BORN\n", c._id)
 		}
 	}
 }
 
-func (c *cell) evolve() {
-	if c.alive {
-		if c._neighbours < 2 || c._neighbours > 3 {
-			c.alive = false
-			//fmt.Printf("%d DIES\n", c._id)
-		}
-	} else {
-		if c._neighbours == 3 {
-			//fmt.Printf("%d BORN\n", c._id)
-			c.alive = true
-		}
-	}
+func (c *cell) evolve(r rule) {
+	c.alive = r.next(c.alive, c._neighbours)
 }
 
 func (g *grid) evolve() {
 	for x := 0; x < g.size; x++ {
 		for y := 0; y < g.size; y++ {
-			g.population[x][y].evolve()
+			g.population[x][y].evolve(g.rule)
 		}
 	}
 	for x := 0; x < g.size; x++ {
@@ -190,7 +181,7 @@ func (g grid) equal(g2 grid) bool {
 
 func (g grid) copy() grid {
 	var g2 grid
-	g2.initialize(0.0, g.size, g.connectedEastWest, g.connectedNorthSouth)
+	g2.initialize(0.0, g.size, g.connectedEastWest, g.connectedNorthSouth, g.rule)
 	for x := 0; x < g.size; x++ {
 		for y := 0; y < g.size; y++ {
 			g2.population[x][y] = g.population[x][y]
@@ -228,10 +219,10 @@ func run(app *tview.Application, visualization *tview.TextView, status *tview.Te
 	}
 }
 
-func ready(app *tview.Application, visualization *tview.TextView, g *grid, population float32, size int, ew bool, ns bool) {
+func ready(app *tview.Application, visualization *tview.TextView, g *grid, population float32, size int, ew bool, ns bool, r rule) {
 	if running == false {
 		rand.Seed(time.Now().UnixNano())
-		g.initialize(population/100, size, ew, ns)
+		g.initialize(population/100, size, ew, ns, r)
 		g.print(app, visualization)
 	}
 }
@@ -256,7 +247,22 @@ func setNS(v bool) {
 	ns = v
 }
 
+func setRule(v string) error {
+	r, err := parseRule(v)
+	if err == nil {
+		lifeRule = r
+	}
+	return err
+}
+
 func main() {
+	ruleFlag := flag.String("rule", rulePresets[0].rule, "rule in B/S notation (B36/S23) or the name of a preset (HighLife, Seeds...)")
+	flag.Parse()
+	if err := setRule(*ruleFlag); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
 	app := tview.NewApplication()
 	setSize("20")
 	setPopulation("30")
@@ -272,7 +278,7 @@ func main() {
 			app.Draw()
 		})
 	visualization.SetBorder(true).SetTitle("WORLD").SetTitleAlign(tview.AlignCenter)
-	ready(app, visualization, &g, population, size, ew, ns)
+	ready(app, visualization, &g, population, size, ew, ns, lifeRule)
 
 	status := tview.NewTextView().
 		SetText("READY").
@@ -283,29 +289,56 @@ func main() {
 		})
 	status.SetBorder(true).SetTitle("Status").SetTitleAlign(tview.AlignCenter)
 
+	ruleField := tview.NewInputField().
+		SetLabel("Rule (B/S)").
+		SetText(lifeRule.String()).
+		SetChangedFunc(func(v string) {
+			if err := setRule(v); err != nil {
+				status.SetText("INVALID RULE")
+				return
+			}
+			ready(app, visualization, &g, population, size, ew, ns, lifeRule)
+			status.SetText("READY")
+		})
+	var presets []string
+	preset := -1
+	for i, p := range rulePresets {
+		presets = append(presets, p.name)
+		if r, _ := parseRule(p.rule); r == lifeRule {
+			preset = i
+		}
+	}
+
 	configuration := tview.NewForm().
 		AddInputField("Size", "20", 0, isNumeric, func(v string) {
 			setSize(v)
-			ready(app, visualization, &g, population, size, ew, ns)
+			ready(app, visualization, &g, population, size, ew, ns, lifeRule)
 			status.SetText("READY")
 		}).
 		AddInputField("% populated", "30", 0, isNumeric, func(v string) {
 			setPopulation(v)
-			ready(app, visualization, &g, population, size, ew, ns)
+			ready(app, visualization, &g, population, size, ew, ns, lifeRule)
 			status.SetText("READY")
 		}).
 		AddCheckbox("Connected East-West", false, func(v bool) {
 			setEW(v)
-			ready(app, visualization, &g, population, size, ew, ns)
+			ready(app, visualization, &g, population, size, ew, ns, lifeRule)
 			status.SetText("READY")
 		}).
 		AddCheckbox("Connected North-South", false, func(v bool) {
 			setNS(v)
-			ready(app, visualization, &g, population, size, ew, ns)
+			ready(app, visualization, &g, population, size, ew, ns, lifeRule)
 			status.SetText("READY")
 		}).
+		AddDropDown("Preset", presets, preset, func(option string, index int) {
+			// also called with -1 when the rule from the command line is not a preset
+			if index >= 0 {
+				ruleField.SetText(rulePresets[index].rule)
+			}
+		}).
+		AddFormItem(ruleField).
 		AddButton("Reseed", func() {
-			ready(app, visualization, &g, population, size, ew, ns)
+			ready(app, visualization, &g, population, size, ew, ns, lifeRule)
 			status.SetText("READY")
 		}).
 		AddButton("Start", func() {
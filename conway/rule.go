@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// rule of a Life-like automaton: how many alive neighbours make a cell born, or survive
+type rule struct {
+	birth    [9]bool
+	survival [9]bool
+}
+
+type rulePreset struct {
+	name string
+	rule string
+}
+
+// the well known ones, the first is the default
+var rulePresets = []rulePreset{
+	{"Conway's Life", "B3/S23"},
+	{"HighLife", "B36/S23"},
+	{"Seeds", "B2/S"},
+	{"Day & Night", "B3678/S34678"},
+	{"Life without Death", "B3/S012345678"},
+	{"2x2", "B36/S125"},
+	{"Maze", "B3/S12345"},
+	{"Replicator", "B1357/S1357"},
+}
+
+func parseCounts(s string, counts *[9]bool) error {
+	for _, c := range s {
+		if c < '0' || c > '8' {
+			return fmt.Errorf("invalid neighbour count %q", c)
+		}
+		counts[c-'0'] = true
+	}
+	return nil
+}
+
+/*
+parseRule reads a rulestring: B36/S23 (also lowercase, or S23/B36), the older
+survival/birth notation 23/36, or the name of a preset.
+*/
+func parseRule(s string) (rule, error) {
+	var r rule
+	s = strings.TrimSpace(s)
+	for _, p := range rulePresets {
+		if strings.EqualFold(s, p.name) {
+			s = p.rule
+			break
+		}
+	}
+	parts := strings.Split(strings.ToUpper(s), "/")
+	if len(parts) != 2 {
+		return r, fmt.Errorf("invalid rule %q, expected B3/S23", s)
+	}
+	birth, survival := parts[0], parts[1]
+	switch {
+	case strings.HasPrefix(birth, "B") && strings.HasPrefix(survival, "S"):
+	case strings.HasPrefix(birth, "S") && strings.HasPrefix(survival, "B"):
+		birth, survival = survival, birth
+	case !strings.ContainsAny(s, "BSbs") && s != "/":
+		// survival/birth
+		birth, survival = "B"+survival, "S"+birth
+	default:
+		return r, fmt.Errorf("invalid rule %q, expected B3/S23", s)
+	}
+	if err := parseCounts(birth[1:], &r.birth); err != nil {
+		return r, fmt.Errorf("invalid rule %q: %v", s, err)
+	}
+	if err := parseCounts(survival[1:], &r.survival); err != nil {
+		return r, fmt.Errorf("invalid rule %q: %v", s, err)
+	}
+	return r, nil
+}
+
+// String is the rule in B/S notation
+func (r rule) String() string {
+	var b strings.Builder
+	b.WriteString("B")
+	for n, ok := range r.birth {
+		if ok {
+			fmt.Fprint(&b, n)
+		}
+	}
+	b.WriteString("/S")
+	for n, ok := range r.survival {
+		if ok {
+			fmt.Fprint(&b, n)
+		}
+	}
+	return b.String()
+}
+
+// next tells if a cell is alive in the next generation
+func (r rule) next(alive bool, neighbours int) bool {
+	if alive {
+		return r.survival[neighbours]
+	}
+	return r.birth[neighbours]
+}
@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"B3/S23", "B3/S23"},
+		{"S23/B3", "B3/S23"},
+		{"b36/s23", "B36/S23"},
+		{" B3678/S34678 ", "B3678/S34678"},
+		{"23/3", "B3/S23"},
+		{"1357/1357", "B1357/S1357"},
+		{"B2/S", "B2/S"},
+		{"highlife", "B36/S23"},
+		{"Day & Night", "B3678/S34678"},
+		{"B63/S32", "B36/S23"},
+	}
+	for _, tt := range tests {
+		r, err := parseRule(tt.input)
+		if err != nil {
+			t.Errorf("parseRule(%q): %v", tt.input, err)
+			continue
+		}
+		if got := r.String(); got != tt.want {
+			t.Errorf("parseRule(%q) = %s, want %s", tt.input, got, tt.want)
+		}
+	}
+
+	for _, input := range []string{"", "/", "B3", "B3/S23/", "B9/S23", "B3/S2x", "B3/B23", "S23/S3", "X3/S23", "3/S23", "life"} {
+		if r, err := parseRule(input); err == nil {
+			t.Errorf("parseRule(%q) = %s, want an error", input, r)
+		}
+	}
+}
+
+func TestRuleStringRoundTrip(t *testing.T) {
+	for _, p := range rulePresets {
+		r, err := parseRule(p.rule)
+		if err != nil {
+			t.Fatalf("%s: %v", p.name, err)
+		}
+		if r.String() != p.rule {
+			t.Errorf("%s: %s, want %s", p.name, r, p.rule)
+		}
+		again, err := parseRule(r.String())
+		if err != nil || again != r {
+			t.Errorf("%s: %s does not parse back: %v", p.name, r, err)
+		}
+	}
+}
+
+func TestRuleNext(t *testing.T) {
+	life, _ := parseRule("B3/S23")
+	for n := 0; n <= 8; n++ {
+		if got, want := life.next(false, n), n == 3; got != want {
+			t.Errorf("dead cell with %d neighbours: %v", n, got)
+		}
+		if got, want := life.next(true, n), n == 2 || n == 3; got != want {
+			t.Errorf("alive cell with %d neighbours: %v", n, got)
+		}
+	}
+}
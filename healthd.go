@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+	healthd: checks services (process running, ports open, HTTP endpoints
+	answering) at an interval, and serves their state as JSON on /status and as
+	Prometheus metrics on /metrics.
+
+	A service changes state only after rise consecutive successes or fall
+	consecutive failures, so that a flapping check doesn't flood the alerts.
+*/
+
+// duration reads "10s", "1m30s"... from YAML and TOML
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+func (d duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+type httpCheckConfig struct {
+	URL    string `yaml:"url" toml:"url"`
+	Status int    `yaml:"status" toml:"status"` // expected status, any below 400 when zero
+}
+
+type serviceConfig struct {
+	Name    string            `yaml:"name" toml:"name"`
+	Process string            `yaml:"process" toml:"process"` // name, as for pidof
+	Host    string            `yaml:"host" toml:"host"`       // of the ports, 127.0.0.1 when empty
+	Ports   []int             `yaml:"ports" toml:"ports"`
+	Reach   []string          `yaml:"reach" toml:"reach"` // network:address, e.g. udp:127.0.0.1:53 or unix:/run/app.sock
+	HTTP    []httpCheckConfig `yaml:"http" toml:"http"`
+}
+
+type healthConfig struct {
+	Listen   string          `yaml:"listen" toml:"listen"`
+	Interval duration        `yaml:"interval" toml:"interval"`
+	Timeout  duration        `yaml:"timeout" toml:"timeout"`
+	Rise     int             `yaml:"rise" toml:"rise"` // consecutive successes to go up
+	Fall     int             `yaml:"fall" toml:"fall"` // consecutive failures to go down
+	Services []serviceConfig `yaml:"services" toml:"services"`
+}
+
+// loadHealthConfig reads a .toml file, or YAML for any other extension
+func loadHealthConfig(path string) (*healthConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &healthConfig{
+		Listen:   "127.0.0.1:9110",
+		Interval: duration{10 * time.Second},
+		Timeout:  duration{2 * time.Second},
+		Rise:     2,
+		Fall:     3,
+	}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		err = toml.Unmarshal(content, config)
+	} else {
+		err = yaml.Unmarshal(content, config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if config.Interval.Duration <= 0 || config.Timeout.Duration <= 0 || config.Rise < 1 || config.Fall < 1 {
+		return nil, fmt.Errorf("%s: interval and timeout must be positive, rise and fall at least 1", path)
+	}
+	names := make(map[string]bool)
+	for _, s := range config.Services {
+		if s.Name == "" || names[s.Name] {
+			return nil, fmt.Errorf("%s: every service needs a unique name", path)
+		}
+		names[s.Name] = true
+		if s.Process == "" && len(s.Ports) == 0 && len(s.Reach) == 0 && len(s.HTTP) == 0 {
+			return nil, fmt.Errorf("%s: service %s has nothing to check", path, s.Name)
+		}
+		for _, spec := range s.Reach {
+			if _, err := parseReach(spec); err != nil {
+				return nil, fmt.Errorf("%s: service %s: %v", path, s.Name, err)
+			}
+		}
+	}
+	return config, nil
+}
+
+type checkResult struct {
+	Name    string        `json:"name"`
+	OK      bool          `json:"ok"`
+	Error   string        `json:"error,omitempty"`
+	Latency time.Duration `json:"latency"`
+}
+
+const (
+	stateUnknown = "unknown"
+	stateUp      = "up"
+	stateDown    = "down"
+)
+
+type serviceStatus struct {
+	Name        string        `json:"name"`
+	State       string        `json:"state"`
+	Since       time.Time     `json:"since"`
+	LastCheck   time.Time     `json:"last_check"`
+	Transitions int           `json:"transitions"`
+	Checks      []checkResult `json:"checks"`
+
+	successes int // consecutive
+	failures  int
+}
+
+// runChecks evaluates every check of the service
+func runChecks(ctx context.Context, s serviceConfig, timeout time.Duration) []checkResult {
+	var results []checkResult
+	if s.Process != "" {
+		start := time.Now()
+		r := checkResult{Name: "process " + s.Process}
+		procs, err := defaultProcFS.find(processQuery{Name: s.Process})
+		switch {
+		case err != nil:
+			r.Error = err.Error()
+		case len(procs) == 0:
+			r.Error = "not running"
+		default:
+			r.OK = true
+		}
+		r.Latency = time.Since(start)
+		results = append(results, r)
+	}
+
+	host := s.Host
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	for _, port := range s.Ports {
+		dial, conn := dialPort(ctx, host, port, timeout)
+		r := checkResult{Name: fmt.Sprintf("port %d", port), OK: conn != nil, Error: dial.Error, Latency: dial.Latency}
+		if conn != nil {
+			conn.Close()
+		}
+		results = append(results, r)
+	}
+	for _, spec := range s.Reach {
+		c, _ := parseReach(spec)
+		c.Timeout = timeout
+		reach := c.check(ctx)
+		r := checkResult{Name: "reach " + spec, OK: reach.Status == reachSuccess, Latency: reach.Latency}
+		if !r.OK {
+			r.Error = reach.Status + ": " + reach.Error
+		}
+		results = append(results, r)
+	}
+
+	client := &http.Client{Timeout: timeout}
+	for _, h := range s.HTTP {
+		r := checkResult{Name: "http " + h.URL}
+		start := time.Now()
+		req, err := http.NewRequestWithContext(ctx, "GET", h.URL, nil)
+		if err == nil {
+			var resp *http.Response
+			if resp, err = client.Do(req); err == nil {
+				resp.Body.Close()
+				switch {
+				case h.Status != 0 && resp.StatusCode != h.Status:
+					r.Error = fmt.Sprintf("status %d, expected %d", resp.StatusCode, h.Status)
+				case h.Status == 0 && resp.StatusCode >= 400:
+					r.Error = "status " + resp.Status
+				default:
+					r.OK = true
+				}
+			}
+		}
+		if err != nil {
+			r.Error = err.Error()
+		}
+		r.Latency = time.Since(start)
+		results = append(results, r)
+	}
+	return results
+}
+
+type healthDaemon struct {
+	config *healthConfig
+	mu     sync.Mutex
+	status map[string]*serviceStatus
+}
+
+func newHealthDaemon(config *healthConfig) *healthDaemon {
+	d := &healthDaemon{config: config, status: make(map[string]*serviceStatus)}
+	now := time.Now()
+	for _, s := range config.Services {
+		d.status[s.Name] = &serviceStatus{Name: s.Name, State: stateUnknown, Since: now}
+	}
+	return d
+}
+
+// record applies rise and fall: the state changes only after enough results in a row
+func (d *healthDaemon) record(name string, checks []checkResult, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	st := d.status[name]
+	st.Checks = checks
+	st.LastCheck = now
+	ok := true
+	for _, c := range checks {
+		ok = ok && c.OK
+	}
+	if ok {
+		st.successes++
+		st.failures = 0
+	} else {
+		st.failures++
+		st.successes = 0
+	}
+
+	next := st.State
+	if st.State != stateUp && st.successes >= d.config.Rise {
+		next = stateUp
+	}
+	if st.State != stateDown && st.failures >= d.config.Fall {
+		next = stateDown
+	}
+	if next != st.State {
+		if st.State != stateUnknown {
+			st.Transitions++
+		}
+		log.Printf("%s: %s -> %s", name, st.State, next)
+		st.State = next
+		st.Since = now
+	}
+}
+
+// checkAll runs the checks of every service concurrently
+func (d *healthDaemon) checkAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, s := range d.config.Services {
+		wg.Add(1)
+		go func(s serviceConfig) {
+			defer wg.Done()
+			d.record(s.Name, runChecks(ctx, s, d.config.Timeout.Duration), time.Now())
+		}(s)
+	}
+	wg.Wait()
+}
+
+// run checks every interval until the context is done
+func (d *healthDaemon) run(ctx context.Context) {
+	ticker := time.NewTicker(d.config.Interval.Duration)
+	defer ticker.Stop()
+	for {
+		d.checkAll(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// snapshot copies the status, sorted by service name
+func (d *healthDaemon) snapshot() []serviceStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var statuses []serviceStatus
+	for _, st := range d.status {
+		statuses = append(statuses, *st)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+func (d *healthDaemon) serveStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(d.snapshot())
+}
+
+// prometheusLabel escapes a label value
+func prometheusLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func (d *healthDaemon) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	statuses := d.snapshot()
+	fmt.Fprintln(w, "# HELP healthd_service_up 1 when the service is up, 0 when down, -1 before enough checks.")
+	fmt.Fprintln(w, "# TYPE healthd_service_up gauge")
+	for _, st := range statuses {
+		up := -1
+		switch st.State {
+		case stateUp:
+			up = 1
+		case stateDown:
+			up = 0
+		}
+		fmt.Fprintf(w, "healthd_service_up{service=\"%s\"} %d\n", prometheusLabel(st.Name), up)
+	}
+	fmt.Fprintln(w, "# HELP healthd_service_transitions_total State changes of the service, after rise and fall.")
+	fmt.Fprintln(w, "# TYPE healthd_service_transitions_total counter")
+	for _, st := range statuses {
+		fmt.Fprintf(w, "healthd_service_transitions_total{service=\"%s\"} %d\n", prometheusLabel(st.Name), st.Transitions)
+	}
+	fmt.Fprintln(w, "# HELP healthd_check_ok 1 when the last run of the check succeeded.")
+	fmt.Fprintln(w, "# TYPE healthd_check_ok gauge")
+	for _, st := range statuses {
+		for _, c := range st.Checks {
+			ok := 0
+			if c.OK {
+				ok = 1
+			}
+			fmt.Fprintf(w, "healthd_check_ok{service=\"%s\",check=\"%s\"} %d\n", prometheusLabel(st.Name), prometheusLabel(c.Name), ok)
+		}
+	}
+	fmt.Fprintln(w, "# HELP healthd_check_duration_seconds Duration of the last run of the check.")
+	fmt.Fprintln(w, "# TYPE healthd_check_duration_seconds gauge")
+	for _, st := range statuses {
+		for _, c := range st.Checks {
+			fmt.Fprintf(w, "healthd_check_duration_seconds{service=\"%s\",check=\"%s\"} %g\n", prometheusLabel(st.Name), prometheusLabel(c.Name), c.Latency.Seconds())
+		}
+	}
+}
+
+func (d *healthDaemon) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", d.serveStatus)
+	mux.HandleFunc("/metrics", d.serveMetrics)
+	return mux
+}
+
+func runHealthd(args []string) int {
+	fs := flag.NewFlagSet("healthd", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: utils healthd [flags]\n\nflags:\n")
+		fs.PrintDefaults()
+	}
+	configPath := fs.String("config", "healthd.yaml", "configuration, YAML or TOML (.toml)")
+	listen := fs.String("listen", "", "address of /status and /metrics, overrides the configuration")
+	fs.Parse(args)
+
+	config, err := loadHealthConfig(*configPath)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	if *listen != "" {
+		config.Listen = *listen
+	}
+	d := newHealthDaemon(config)
+	go d.run(context.Background())
+	log.Printf("checking %d services every %s, status on http://%s/status", len(config.Services), config.Interval, config.Listen)
+	log.Println(http.ListenAndServe(config.Listen, d.handler()))
+	return 1
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthDaemonRecord(t *testing.T) {
+	tests := []struct {
+		name        string
+		results     string // one character per run: + all checks pass, - one fails
+		states      string // state after each run: ? unknown, U up, D down
+		transitions int
+	}{
+		{"rise", "++", "?U", 0},
+		{"fall", "---", "??D", 0},
+		{"flapping while unknown", "+-+-+-", "??????", 0},
+		{"flapping while up", "++-+--+-", "?UUUUUUU", 0},
+		{"down after fall failures", "++---", "?UUUD", 1},
+		{"failures are reset by a success", "++--+---", "?UUUUUUD", 1},
+		{"back up", "++---+-++", "?UUUDDDDU", 2},
+	}
+	letters := map[string]byte{stateUnknown: '?', stateUp: 'U', stateDown: 'D'}
+	for _, tt := range tests {
+		config := &healthConfig{Rise: 2, Fall: 3, Services: []serviceConfig{{Name: "web"}}}
+		d := newHealthDaemon(config)
+		now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		var states []byte
+		var since time.Time
+		for i, r := range tt.results {
+			checks := []checkResult{{Name: "port 80", OK: true}, {Name: "http", OK: r == '+'}}
+			previous := d.status["web"].State
+			d.record("web", checks, now.Add(time.Duration(i)*time.Second))
+			st := d.status["web"]
+			states = append(states, letters[st.State])
+			if st.State != previous {
+				since = st.LastCheck
+			}
+		}
+		st := d.snapshot()[0]
+		if string(states) != tt.states || st.Transitions != tt.transitions {
+			t.Errorf("%s: states %s transitions %d, want %s and %d", tt.name, states, st.Transitions, tt.states, tt.transitions)
+		}
+		if !since.IsZero() && !st.Since.Equal(since) {
+			t.Errorf("%s: since %v, want the last change %v", tt.name, st.Since, since)
+		}
+		if len(st.Checks) != 2 || !st.LastCheck.Equal(now.Add(time.Duration(len(tt.results)-1)*time.Second)) {
+			t.Errorf("%s: last checks %+v at %v", tt.name, st.Checks, st.LastCheck)
+		}
+	}
+}
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadHealthConfig(t *testing.T) {
+	yamlConfig := `
+listen: ":9000"
+interval: 30s
+rise: 1
+services:
+  - name: web
+    process: nginx
+    ports: [80, 443]
+    http:
+      - url: http://127.0.0.1/health
+        status: 204
+  - name: dns
+    reach: ["udp:127.0.0.1:53"]
+`
+	tomlConfig := `
+listen = ":9000"
+interval = "30s"
+rise = 1
+
+[[services]]
+name = "web"
+process = "nginx"
+ports = [80, 443]
+
+[[services.http]]
+url = "http://127.0.0.1/health"
+status = 204
+
+[[services]]
+name = "dns"
+reach = ["udp:127.0.0.1:53"]
+`
+	for _, path := range []string{writeConfig(t, "healthd.yaml", yamlConfig), writeConfig(t, "healthd.TOML", tomlConfig)} {
+		config, err := loadHealthConfig(path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if config.Listen != ":9000" || config.Interval.Duration != 30*time.Second || config.Rise != 1 {
+			t.Errorf("%s: %+v", path, config)
+		}
+		// defaults for what is missing
+		if config.Timeout.Duration != 2*time.Second || config.Fall != 3 {
+			t.Errorf("%s: timeout %v fall %d", path, config.Timeout, config.Fall)
+		}
+		if len(config.Services) != 2 {
+			t.Fatalf("%s: services %+v", path, config.Services)
+		}
+		web, dns := config.Services[0], config.Services[1]
+		if web.Name != "web" || web.Process != "nginx" || len(web.Ports) != 2 || web.Ports[1] != 443 ||
+			len(web.HTTP) != 1 || web.HTTP[0].URL != "http://127.0.0.1/health" || web.HTTP[0].Status != 204 {
+			t.Errorf("%s: web %+v", path, web)
+		}
+		if dns.Name != "dns" || len(dns.Reach) != 1 || dns.Reach[0] != "udp:127.0.0.1:53" {
+			t.Errorf("%s: dns %+v", path, dns)
+		}
+	}
+
+	bad := []struct {
+		name, content string
+	}{
+		{"syntax.yaml", "services: [\n"},
+		{"syntax.toml", "services = \n"},
+		{"duration.yaml", "interval: often\n"},
+		{"timeout.toml", "timeout = \"0s\"\n"},
+		{"rise.yaml", "rise: 0\n"},
+		{"fall.yaml", "fall: -1\n"},
+		{"unnamed.yaml", "services:\n  - ports: [80]\n"},
+		{"duplicate.yaml", "services:\n  - name: web\n    ports: [80]\n  - name: web\n    ports: [443]\n"},
+		{"empty.yaml", "services:\n  - name: web\n"},
+		{"reach.yaml", "services:\n  - name: dns\n    reach: [\"127.0.0.1:53\"]\n"},
+	}
+	for _, b := range bad {
+		if _, err := loadHealthConfig(writeConfig(t, b.name, b.content)); err == nil {
+			t.Errorf("%s accepted", b.name)
+		}
+	}
+	if _, err := loadHealthConfig(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("missing file accepted")
+	}
+}
+
+func TestHealthDaemonHandler(t *testing.T) {
+	config := &healthConfig{Rise: 1, Fall: 1, Services: []serviceConfig{{Name: "web"}, {Name: `db "main"`}, {Name: "idle"}}}
+	d := newHealthDaemon(config)
+	now := time.Now()
+	d.record("web", []checkResult{{Name: "port 80", OK: true, Latency: 1500 * time.Microsecond}}, now)
+	d.record(`db "main"`, []checkResult{{Name: "port 5432", OK: false, Error: "connection refused", Latency: time.Millisecond}}, now)
+	server := httptest.NewServer(d.handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statuses []serviceStatus
+	err = json.NewDecoder(resp.Body).Decode(&statuses)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Content-Type") != "application/json" || len(statuses) != 3 {
+		t.Fatalf("status %s: %+v", resp.Header.Get("Content-Type"), statuses)
+	}
+	// sorted by name
+	db, idle, web := statuses[0], statuses[1], statuses[2]
+	if db.Name != `db "main"` || db.State != stateDown || len(db.Checks) != 1 || db.Checks[0].Error != "connection refused" {
+		t.Errorf("db: %+v", db)
+	}
+	if idle.State != stateUnknown || idle.Checks != nil {
+		t.Errorf("idle: %+v", idle)
+	}
+	if web.State != stateUp || !web.Checks[0].OK || web.Checks[0].Latency != 1500*time.Microsecond {
+		t.Errorf("web: %+v", web)
+	}
+
+	resp, err = http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := string(body)
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("metrics content type %s", resp.Header.Get("Content-Type"))
+	}
+	for _, want := range []string{
+		"# TYPE healthd_service_up gauge\n",
+		`healthd_service_up{service="db \"main\""} 0` + "\n",
+		`healthd_service_up{service="idle"} -1` + "\n",
+		`healthd_service_up{service="web"} 1` + "\n",
+		"# TYPE healthd_service_transitions_total counter\n",
+		`healthd_service_transitions_total{service="web"} 0` + "\n",
+		`healthd_check_ok{service="db \"main\"",check="port 5432"} 0` + "\n",
+		`healthd_check_ok{service="web",check="port 80"} 1` + "\n",
+		`healthd_check_duration_seconds{service="web",check="port 80"} 0.0015` + "\n",
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics miss %q:\n%s", want, metrics)
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSpace(metrics), "\n") {
+		if !strings.HasPrefix(line, "# ") && !strings.HasPrefix(line, "healthd_") {
+			t.Errorf("malformed line %q", line)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// subcommands of the utils binary
+var commands = map[string]func([]string) int{
+	"healthd":   runHealthd,
+	"ports":     runPorts,
+	"sample":    runSample,
+	"scan":      runScan,
+	"supervise": runSupervise,
+	"tls":       runTLS,
+	"tree":      runTree,
+	"wait":      runWait,
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: utils <command> [flags]\n\ncommands:\n")
+	fmt.Fprintf(os.Stderr, "  healthd    check services, serve /status and /metrics\n")
+	fmt.Fprintf(os.Stderr, "  ports      list listening sockets and the processes owning them\n")
+	fmt.Fprintf(os.Stderr, "  sample     sample the CPU, memory and file descriptors of a process\n")
+	fmt.Fprintf(os.Stderr, "  scan       scan the TCP ports of hosts and networks\n")
+	fmt.Fprintf(os.Stderr, "  supervise  start commands and restart them when they die\n")
+	fmt.Fprintf(os.Stderr, "  tls        check the TLS handshake and the certificates of servers\n")
+	fmt.Fprintf(os.Stderr, "  tree       show or signal a process tree, a process group or a session\n")
+	fmt.Fprintf(os.Stderr, "  wait       wait for ports, processes or exits, then run a command\n")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	os.Exit(run(os.Args[2:]))
+}
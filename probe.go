@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	Service detection.
+
+	Each probe opens its own connection, the first one recognizing the service wins:
+	1. wait for a banner, for the protocols where the server speaks first (SSH,
+	   SMTP, which is also sent EHLO, FTP, POP3, IMAP);
+	2. TLS handshake, for the certificate subject and expiry, then HTTP HEAD inside;
+	3. plain HTTP HEAD;
+	4. Redis PING.
+*/
+
+type certInfo struct {
+	Subject        string    `json:"subject"`
+	Issuer         string    `json:"issuer"`
+	DNSNames       []string  `json:"dns_names,omitempty"`
+	IPAddresses    []string  `json:"ip_addresses,omitempty"`
+	EmailAddresses []string  `json:"email_addresses,omitempty"`
+	URIs           []string  `json:"uris,omitempty"`
+	NotAfter       time.Time `json:"not_after"`
+}
+
+// newCertInfo keeps the names and every kind of subject alternative name of the certificate
+func newCertInfo(cert *x509.Certificate) certInfo {
+	info := certInfo{
+		Subject:        cert.Subject.String(),
+		Issuer:         cert.Issuer.String(),
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		NotAfter:       cert.NotAfter,
+	}
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		info.URIs = append(info.URIs, uri.String())
+	}
+	return info
+}
+
+type serviceInfo struct {
+	Service string    `json:"service"`          // ssh, smtp, ftp, pop3, imap, http, https, tls, redis, or empty when unknown
+	Banner  string    `json:"banner,omitempty"` // first line sent by the server
+	Detail  string    `json:"detail,omitempty"` // SMTP extensions, HTTP Server header...
+	Cert    *certInfo `json:"cert,omitempty"`
+}
+
+// String is a one line summary, for utils scan -probe
+func (info serviceInfo) String() string {
+	parts := []string{info.Service}
+	if info.Service == "" {
+		parts[0] = "unknown"
+	}
+	switch {
+	case info.Detail != "":
+		parts = append(parts, info.Detail)
+	case info.Banner != "":
+		parts = append(parts, strconv.Quote(info.Banner))
+	}
+	if info.Cert != nil {
+		parts = append(parts, "cert "+info.Cert.Subject+" until "+info.Cert.NotAfter.Format("2006-01-02"))
+	}
+	return strings.Join(parts, " ")
+}
+
+// probe reads at most 4KB, until done says so, the server closes or the timeout expires
+func probe(conn net.Conn, send string, timeout time.Duration, done func([]byte) bool) []byte {
+	conn.SetDeadline(time.Now().Add(timeout))
+	if send != "" {
+		if _, err := conn.Write([]byte(send)); err != nil {
+			return nil
+		}
+	}
+	var received []byte
+	buf := make([]byte, 4096)
+	for len(received) < len(buf) {
+		n, err := conn.Read(buf[:len(buf)-len(received)])
+		received = append(received, buf[:n]...)
+		if err != nil || done(received) {
+			break
+		}
+	}
+	return received
+}
+
+func endOfLine(b []byte) bool {
+	return bytes.Contains(b, []byte("\n"))
+}
+
+func endOfHeaders(b []byte) bool {
+	return bytes.Contains(b, []byte("\r\n\r\n")) || bytes.Contains(b, []byte("\n\n"))
+}
+
+// endOfSMTPReply is true after the last line of a reply, "250 " instead of "250-"
+func endOfSMTPReply(b []byte) bool {
+	lines := strings.Split(strings.TrimRight(string(b), "\r\n"), "\n")
+	last := strings.TrimRight(lines[len(lines)-1], "\r")
+	return strings.HasSuffix(string(b), "\n") && len(last) >= 4 && last[3] == ' '
+}
+
+func firstLine(b []byte) string {
+	line, _, _ := bufio.NewReader(bytes.NewReader(b)).ReadLine()
+	return strings.TrimSpace(string(line))
+}
+
+// httpServer is the Server header of the response, or the status line
+func httpServer(response []byte) string {
+	for _, line := range strings.Split(string(response), "\n") {
+		if i := strings.Index(line, ":"); i > 0 && strings.EqualFold(line[:i], "server") {
+			return strings.TrimSpace(line[i+1:])
+		}
+	}
+	return firstLine(response)
+}
+
+func httpHead(host string) string {
+	return "HEAD / HTTP/1.0\r\nHost: " + host + "\r\nUser-Agent: probe\r\n\r\n"
+}
+
+// probeBanner waits for the server to speak first
+func probeBanner(conn net.Conn, timeout time.Duration) (serviceInfo, bool) {
+	banner := probe(conn, "", timeout, endOfLine)
+	info := serviceInfo{Banner: firstLine(banner)}
+	switch {
+	case info.Banner == "":
+		return info, false
+	case strings.HasPrefix(info.Banner, "SSH-"):
+		info.Service = "ssh"
+	case strings.HasPrefix(info.Banner, "+OK"):
+		info.Service = "pop3"
+	case strings.HasPrefix(info.Banner, "* OK"):
+		info.Service = "imap"
+	case strings.HasPrefix(info.Banner, "220"):
+		// SMTP and FTP both greet with 220, only SMTP understands EHLO
+		reply := probe(conn, "EHLO probe.local\r\n", timeout, endOfSMTPReply)
+		if strings.HasPrefix(string(reply), "250") {
+			info.Service = "smtp"
+			var extensions []string
+			for _, line := range strings.Split(string(reply), "\n")[1:] {
+				if line = strings.TrimSpace(line); len(line) > 4 {
+					extensions = append(extensions, line[4:])
+				}
+			}
+			info.Detail = strings.Join(extensions, " ")
+			conn.Write([]byte("QUIT\r\n"))
+		} else {
+			info.Service = "ftp"
+		}
+	}
+	return info, true
+}
+
+/*
+probeService tells which service listens on host:port. Every probe has the
+timeout, so an unknown silent service costs a few of them. When nothing is
+recognized, Service is empty and Banner has what the server sent, if anything.
+*/
+func probeService(ctx context.Context, host string, port int, timeout time.Duration) serviceInfo {
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	dialer := &net.Dialer{Timeout: timeout}
+
+	if conn, err := dialer.DialContext(ctx, "tcp", addr); err == nil {
+		info, ok := probeBanner(conn, timeout)
+		conn.Close()
+		if ok {
+			return info
+		}
+	}
+
+	config := &tls.Config{InsecureSkipVerify: true}
+	if net.ParseIP(host) == nil {
+		config.ServerName = host
+	}
+	tlsDialer := &tls.Dialer{NetDialer: dialer, Config: config}
+	if conn, err := tlsDialer.DialContext(ctx, "tcp", addr); err == nil {
+		info := serviceInfo{Service: "tls"}
+		state := conn.(*tls.Conn).ConnectionState()
+		if len(state.PeerCertificates) > 0 {
+			cert := newCertInfo(state.PeerCertificates[0])
+			info.Cert = &cert
+		}
+		if response := probe(conn, httpHead(host), timeout, endOfHeaders); strings.HasPrefix(string(response), "HTTP/") {
+			info.Service = "https"
+			info.Detail = httpServer(response)
+		}
+		conn.Close()
+		return info
+	}
+
+	if conn, err := dialer.DialContext(ctx, "tcp", addr); err == nil {
+		response := probe(conn, httpHead(host), timeout, endOfHeaders)
+		conn.Close()
+		if strings.HasPrefix(string(response), "HTTP/") {
+			return serviceInfo{Service: "http", Banner: firstLine(response), Detail: httpServer(response)}
+		}
+	}
+
+	if conn, err := dialer.DialContext(ctx, "tcp", addr); err == nil {
+		reply := firstLine(probe(conn, "PING\r\n", timeout, endOfLine))
+		conn.Close()
+		// a password protected Redis still answers, with an error
+		if reply == "+PONG" || strings.HasPrefix(reply, "-NOAUTH") || strings.HasPrefix(reply, "-DENIED") || strings.HasPrefix(reply, "-NOPERM") {
+			return serviceInfo{Service: "redis", Banner: reply}
+		}
+		return serviceInfo{Banner: reply}
+	}
+	return serviceInfo{}
+}
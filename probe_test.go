@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeServer listens on 127.0.0.1 and runs handle for every connection
+func fakeServer(t *testing.T, handle func(conn net.Conn)) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func serverPort(t *testing.T, rawurl string) int {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return port
+}
+
+func TestProbeService(t *testing.T) {
+	ssh := fakeServer(t, func(conn net.Conn) {
+		conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+		bufio.NewReader(conn).ReadString('\n')
+	})
+	smtp := fakeServer(t, func(conn net.Conn) {
+		conn.Write([]byte("220 mx.example.org ESMTP\r\n"))
+		r := bufio.NewReader(conn)
+		r.ReadString('\n')
+		conn.Write([]byte("250-mx.example.org\r\n250-PIPELINING\r\n250-STARTTLS\r\n250 8BITMIME\r\n"))
+		r.ReadString('\n')
+	})
+	ftp := fakeServer(t, func(conn net.Conn) {
+		conn.Write([]byte("220 ProFTPD Server\r\n"))
+		bufio.NewReader(conn).ReadString('\n')
+		conn.Write([]byte("500 EHLO not understood\r\n"))
+	})
+	redis := fakeServer(t, func(conn net.Conn) {
+		bufio.NewReader(conn).ReadString('\n')
+		conn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+	})
+	silent := fakeServer(t, func(conn net.Conn) {
+		conn.Read(make([]byte, 1))
+	})
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx/1.25")
+	}))
+	defer web.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "caddy")
+	}))
+	defer secure.Close()
+
+	tests := []struct {
+		port            int
+		service, detail string
+	}{
+		{ssh, "ssh", ""},
+		{smtp, "smtp", "PIPELINING STARTTLS 8BITMIME"},
+		{ftp, "ftp", ""},
+		{redis, "redis", ""},
+		{silent, "", ""},
+		{serverPort(t, web.URL), "http", "nginx/1.25"},
+		{serverPort(t, secure.URL), "https", "caddy"},
+	}
+	for _, test := range tests {
+		info := probeService(context.Background(), "127.0.0.1", test.port, 300*time.Millisecond)
+		if info.Service != test.service || info.Detail != test.detail {
+			t.Errorf("port %d: got %q %q, want %q %q", test.port, info.Service, info.Detail, test.service, test.detail)
+		}
+		if test.service == "https" && (info.Cert == nil || info.Cert.NotAfter.IsZero()) {
+			t.Errorf("https: no certificate %+v", info.Cert)
+		}
+	}
+}
+
+func TestScanProbe(t *testing.T) {
+	ssh := fakeServer(t, func(conn net.Conn) {
+		conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+	})
+	closed := closedPort(t)
+	results, err := portScanner{Probe: true, Timeout: 300 * time.Millisecond}.scan(context.Background(), []string{"127.0.0.1"}, []int{ssh, closed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		switch r.Port {
+		case ssh:
+			if r.Service == nil || r.Service.Service != "ssh" || r.Service.String() != `ssh "SSH-2.0-OpenSSH_9.6"` {
+				t.Errorf("ssh: %+v", r.Service)
+			}
+		case closed:
+			if r.Service != nil {
+				t.Errorf("closed port probed: %+v", r.Service)
+			}
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	Process lookup on /proc.
+
+	Everything is read relative to the procfs root, never with os.Chdir, so a
+	fake root directory with the same layout (PID/stat, PID/status, PID/cmdline,
+	PID/exe and stat for the boot time) works as well as the real /proc.
+*/
+
+// USER_HZ, the unit of the times in /proc/PID/stat, is fixed by the kernel ABI
+const clockTicks = 100
+
+type process struct {
+	PID       int       `json:"pid"`
+	PPID      int       `json:"ppid"`
+	PGID      int       `json:"pgid"` // process group
+	Session   int       `json:"session"`
+	Comm      string    `json:"comm"`           // name in /proc/PID/stat, at most 15 chars
+	Args      []string  `json:"args,omitempty"` // cmdline, empty for kernel threads
+	Exe       string    `json:"exe,omitempty"`  // resolved executable, empty when unreadable
+	State     string    `json:"state"`          // R running, S sleeping, D disk sleep, Z zombie, T stopped...
+	UID       int       `json:"uid"`
+	User      string    `json:"user"`
+	Threads   int       `json:"threads"`
+	StartTime time.Time `json:"start_time"`
+}
+
+type procFS struct {
+	root string
+}
+
+var defaultProcFS = procFS{root: "/proc"}
+
+func newProcFS(root string) procFS {
+	return procFS{root: root}
+}
+
+func (fs procFS) path(elem ...string) string {
+	return filepath.Join(append([]string{fs.root}, elem...)...)
+}
+
+// pids lists the numeric directories of the root
+func (fs procFS) pids() ([]int, error) {
+	files, err := ioutil.ReadDir(fs.root)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %v", fs.root, err)
+	}
+	var pids []int
+	for _, file := range files {
+		// Our directory name should convert to integer if it's a PID
+		if !file.IsDir() {
+			continue
+		}
+		if pid, err := strconv.Atoi(file.Name()); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+	return pids, nil
+}
+
+// bootTime is the btime line of /proc/stat
+func (fs procFS) bootTime() (time.Time, error) {
+	f, err := os.Open(fs.path("stat"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) == 2 && fields[0] == "btime" {
+			seconds, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(seconds, 0), nil
+		}
+	}
+	return time.Time{}, errors.New("btime not found in " + fs.path("stat"))
+}
+
+// statFields splits /proc/PID/stat, the comm between parentheses can contain spaces and parentheses:
+// fields[0] is the comm, fields[n] is the field n+2 of proc(5) (fields[1] the state, fields[2] the ppid...)
+func statFields(content string) ([]string, error) {
+	start := strings.Index(content, "(")
+	end := strings.LastIndex(content, ")")
+	if start < 0 || end < start {
+		return nil, errors.New("malformed stat")
+	}
+	return append([]string{content[start+1 : end]}, strings.Fields(content[end+1:])...), nil
+}
+
+// process reads one process, the error is os.ErrNotExist when it is gone
+func (fs procFS) process(pid int) (*process, error) {
+	boot, _ := fs.bootTime()
+	return fs.readProcess(pid, boot)
+}
+
+func (fs procFS) readProcess(pid int, boot time.Time) (*process, error) {
+	dir := strconv.Itoa(pid)
+	content, err := ioutil.ReadFile(fs.path(dir, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	fields, err := statFields(string(content))
+	if err != nil || len(fields) < 21 {
+		return nil, fmt.Errorf("%s: malformed stat", fs.path(dir, "stat"))
+	}
+	p := &process{PID: pid, Comm: fields[0], State: fields[1], UID: -1}
+	p.PPID, _ = strconv.Atoi(fields[2])
+	p.PGID, _ = strconv.Atoi(fields[3])
+	p.Session, _ = strconv.Atoi(fields[4])
+	p.Threads, _ = strconv.Atoi(fields[18])
+	if ticks, err := strconv.ParseInt(fields[20], 10, 64); err == nil && !boot.IsZero() {
+		p.StartTime = boot.Add(time.Duration(ticks) * time.Second / clockTicks)
+	}
+
+	// real uid, the first of the four on the Uid line
+	if status, err := ioutil.ReadFile(fs.path(dir, "status")); err == nil {
+		for _, line := range strings.Split(string(status), "\n") {
+			if fields := strings.Fields(line); len(fields) > 1 && fields[0] == "Uid:" {
+				p.UID, _ = strconv.Atoi(fields[1])
+			}
+		}
+	}
+	if p.UID >= 0 {
+		p.User = userName(p.UID)
+	}
+
+	if cmdline, err := ioutil.ReadFile(fs.path(dir, "cmdline")); err == nil && len(cmdline) > 0 {
+		p.Args = strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+	}
+	// kernel threads and processes of other users have no readable exe
+	p.Exe, _ = os.Readlink(fs.path(dir, "exe"))
+	p.Exe = strings.TrimSuffix(p.Exe, " (deleted)")
+	return p, nil
+}
+
+// the uid -> name cache, shared by the concurrent checks of healthd and wait
+var userNames = struct {
+	sync.Mutex
+	names map[int]string
+}{names: map[int]string{}}
+
+// userName resolves the uid, or returns it as a string
+func userName(uid int) string {
+	userNames.Lock()
+	defer userNames.Unlock()
+	if name, ok := userNames.names[uid]; ok {
+		return name
+	}
+	name := strconv.Itoa(uid)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	userNames.names[uid] = name
+	return name
+}
+
+// processes reads every process, skipping the ones exiting in the meantime
+func (fs procFS) processes() ([]process, error) {
+	pids, err := fs.pids()
+	if err != nil {
+		return nil, err
+	}
+	boot, _ := fs.bootTime()
+	var procs []process
+	for _, pid := range pids {
+		p, err := fs.readProcess(pid, boot)
+		if err != nil {
+			continue
+		}
+		procs = append(procs, *p)
+	}
+	return procs, nil
+}
+
+/*
+From https://github.com/brgl/busybox/blob/master/libbb/find_pid_by_name.c:
+
+	In Linux we have three ways to determine "process name":
+	1. /proc/PID/stat has "...(name)...", among other things. It's so-called "comm" field.
+	2. /proc/PID/cmdline's first NUL-terminated string. It's argv[0] from exec syscall.
+	3. /proc/PID/exe symlink. Points to the running executable file.
+	kernel threads:
+		comm: thread name
+		cmdline: empty
+		exe: <readlink fails>
+	executable
+		comm: first 15 chars of base name
+		(if executable is a symlink, then first 15 chars of symlink name are used)
+		cmdline: argv[0] from exec syscall
+		exe: points to executable (resolves symlink, unlike comm)
+	script (an executable with #!/path/to/interpreter):
+		comm: first 15 chars of script's base name (symlinks are not resolved)
+		cmdline: /path/to/interpreter (symlinks are not resolved)
+		(script name is in argv[1], args are pushed into argv[2] etc)
+		exe: points to interpreter's executable (symlinks are resolved)
+
+so a query can look at any of them.
+*/
+const (
+	matchComm  = "comm"
+	matchArgv0 = "argv0"
+	matchExe   = "exe"
+)
+
+type processQuery struct {
+	Field   string         // comm, argv0 or exe, empty for any of them
+	Name    string         // exact match of the whole value or of its base name
+	Pattern *regexp.Regexp // regular expression, used instead of Name when set
+}
+
+func (q processQuery) matchValue(value string) bool {
+	if value == "" {
+		return false
+	}
+	if q.Pattern != nil {
+		return q.Pattern.MatchString(value)
+	}
+	return value == q.Name || filepath.Base(value) == q.Name
+}
+
+func (q processQuery) match(p process) bool {
+	argv0 := ""
+	if len(p.Args) > 0 {
+		argv0 = p.Args[0]
+	}
+	switch q.Field {
+	case matchComm:
+		return q.matchValue(p.Comm)
+	case matchArgv0:
+		return q.matchValue(argv0)
+	case matchExe:
+		return q.matchValue(p.Exe)
+	}
+	return q.matchValue(p.Comm) || q.matchValue(argv0) || q.matchValue(p.Exe)
+}
+
+// find returns every process matching the query, in PID order
+func (fs procFS) find(q processQuery) ([]process, error) {
+	switch q.Field {
+	case "", matchComm, matchArgv0, matchExe:
+	default:
+		return nil, fmt.Errorf("unknown process field %q, use comm, argv0 or exe", q.Field)
+	}
+	procs, err := fs.processes()
+	if err != nil {
+		return nil, err
+	}
+	var found []process
+	for _, p := range procs {
+		if q.match(p) {
+			found = append(found, p)
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+)
+
+// fakeProc builds a /proc with three processes: systemd, a python script with an odd comm and a kernel thread
+func fakeProc(t *testing.T) procFS {
+	root := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := func(target, name string) {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("stat", "cpu 1 2 3\nbtime 1700000000\n")
+	write("1/stat", "1 (systemd) S 0 1 1 0 -1 4194560 0 0 0 0 10 20 0 0 20 0 1 0 100 0 0\n")
+	write("1/status", "Name:\tsystemd\nUid:\t0\t0\t0\t0\n")
+	write("1/cmdline", "/sbin/init\x00splash\x00")
+	link("/usr/lib/systemd/systemd", "1/exe")
+	write("42/stat", "42 (my (odd) name) R 1 42 7 0 -1 0 0 0 0 0 0 0 0 0 20 0 3 0 250 0 0\n")
+	write("42/status", "Uid:\t64999\t64999\t64999\t64999\n")
+	write("42/cmdline", "python3\x00app.py\x00")
+	link("/usr/bin/python3.11 (deleted)", "42/exe")
+	write("7/stat", "7 (kworker/0:1) I 2 0 0 0 -1 0 0 0 0 0 0 0 0 0 20 0 1 0 5 0 0\n")
+	// not processes
+	write("self", "")
+	write("sys/kernel/pid_max", "32768\n")
+	return newProcFS(root)
+}
+
+func TestProcesses(t *testing.T) {
+	fs := fakeProc(t)
+	procs, err := fs.processes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(procs) != 3 || procs[0].PID != 1 || procs[1].PID != 7 || procs[2].PID != 42 {
+		t.Fatalf("got %+v", procs)
+	}
+
+	p, err := fs.process(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Comm != "my (odd) name" || p.State != "R" || p.PPID != 1 || p.PGID != 42 || p.Session != 7 || p.Threads != 3 {
+		t.Errorf("stat: %+v", p)
+	}
+	if p.Exe != "/usr/bin/python3.11" || len(p.Args) != 2 || p.Args[1] != "app.py" {
+		t.Errorf("exe %q, args %q", p.Exe, p.Args)
+	}
+	if p.StartTime.Unix() != 1700000002 {
+		t.Errorf("start time %v", p.StartTime)
+	}
+	// by uid, or the uid itself when there's no such user
+	if p.UID != 64999 || p.User != "64999" {
+		t.Errorf("uid %d, user %q", p.UID, p.User)
+	}
+	if procs[0].User != "root" {
+		t.Errorf("uid 0 is %q", procs[0].User)
+	}
+	if kthread := procs[1]; len(kthread.Args) != 0 || kthread.Exe != "" || kthread.UID != -1 {
+		t.Errorf("kernel thread: %+v", kthread)
+	}
+
+	if _, err := fs.process(99); !os.IsNotExist(err) {
+		t.Errorf("missing pid: %v", err)
+	}
+}
+
+func TestFind(t *testing.T) {
+	fs := fakeProc(t)
+	tests := []struct {
+		query processQuery
+		want  []int
+	}{
+		{processQuery{Name: "systemd"}, []int{1}},
+		{processQuery{Field: matchArgv0, Name: "init"}, []int{1}},
+		{processQuery{Field: matchArgv0, Name: "/sbin/init"}, []int{1}},
+		{processQuery{Field: matchExe, Name: "systemd"}, []int{1}},
+		{processQuery{Field: matchComm, Name: "python3"}, nil},
+		{processQuery{Field: matchArgv0, Name: "python3"}, []int{42}},
+		{processQuery{Name: "my (odd) name"}, []int{42}},
+		{processQuery{Pattern: regexp.MustCompile("^kworker")}, []int{7}},
+		{processQuery{Pattern: regexp.MustCompile("s")}, []int{1, 42}},
+		{processQuery{Name: "syst"}, nil},
+	}
+	for _, test := range tests {
+		found, err := fs.find(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pids []int
+		for _, p := range found {
+			pids = append(pids, p.PID)
+		}
+		if len(pids) != len(test.want) {
+			t.Errorf("%+v: got %v, want %v", test.query, pids, test.want)
+			continue
+		}
+		for i := range pids {
+			if pids[i] != test.want[i] {
+				t.Errorf("%+v: got %v, want %v", test.query, pids, test.want)
+			}
+		}
+	}
+	if _, err := fs.find(processQuery{Field: "pid"}); err == nil {
+		t.Error("unknown field accepted")
+	}
+}
+
+// run with -race: healthd and wait look up processes concurrently
+func TestFindConcurrent(t *testing.T) {
+	fs := fakeProc(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := fs.find(processQuery{Name: "systemd"}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// processNode is a process with its children, ordered by PID
+type processNode struct {
+	process
+	Children []*processNode `json:"children,omitempty"`
+}
+
+// processTree links the processes through their parent PID
+type processTree struct {
+	nodes map[int]*processNode
+	roots []*processNode // processes whose parent is not in the tree: init, kthreadd, or orphans of a partial list
+}
+
+func newProcessTree(procs []process) *processTree {
+	t := &processTree{nodes: make(map[int]*processNode)}
+	sort.Slice(procs, func(i, j int) bool { return procs[i].PID < procs[j].PID })
+	for _, p := range procs {
+		t.nodes[p.PID] = &processNode{process: p}
+	}
+	for _, p := range procs {
+		node := t.nodes[p.PID]
+		if parent, ok := t.nodes[p.PPID]; ok && p.PPID != p.PID {
+			parent.Children = append(parent.Children, node)
+		} else {
+			t.roots = append(t.roots, node)
+		}
+	}
+	return t
+}
+
+// tree reads every process and links them
+func (fs procFS) tree() (*processTree, error) {
+	procs, err := fs.processes()
+	if err != nil {
+		return nil, err
+	}
+	return newProcessTree(procs), nil
+}
+
+func (t *processTree) node(pid int) (*processNode, error) {
+	node, ok := t.nodes[pid]
+	if !ok {
+		return nil, fmt.Errorf("no process %d", pid)
+	}
+	return node, nil
+}
+
+// descendants lists children, grandchildren and so on, parents before their children
+func (t *processTree) descendants(pid int) ([]process, error) {
+	node, err := t.node(pid)
+	if err != nil {
+		return nil, err
+	}
+	var procs []process
+	var walk func(n *processNode)
+	walk = func(n *processNode) {
+		for _, child := range n.Children {
+			procs = append(procs, child.process)
+			walk(child)
+		}
+	}
+	walk(node)
+	return procs, nil
+}
+
+// processGroup lists the members of the process group, sorted by PID
+func (t *processTree) processGroup(pgid int) []process {
+	return t.filter(func(p process) bool { return p.PGID == pgid })
+}
+
+// session lists the members of the session, sorted by PID
+func (t *processTree) session(sid int) []process {
+	return t.filter(func(p process) bool { return p.Session == sid })
+}
+
+func (t *processTree) filter(keep func(process) bool) []process {
+	var procs []process
+	for _, node := range t.nodes {
+		if keep(node.process) {
+			procs = append(procs, node.process)
+		}
+	}
+	sort.Slice(procs, func(i, j int) bool { return procs[i].PID < procs[j].PID })
+	return procs
+}
+
+// subtrees returns the tree of pid, or the whole forest when pid is 0
+func (t *processTree) subtrees(pid int) ([]*processNode, error) {
+	if pid == 0 {
+		return t.roots, nil
+	}
+	node, err := t.node(pid)
+	if err != nil {
+		return nil, err
+	}
+	return []*processNode{node}, nil
+}
+
+// commandLine is the cmdline, or the comm in brackets for kernel threads like ps does
+func (p process) commandLine() string {
+	if len(p.Args) == 0 {
+		return "[" + p.Comm + "]"
+	}
+	return strings.Join(p.Args, " ")
+}
+
+// writeText prints the tree of pid (0 for every process) like pstree -p
+func (t *processTree) writeText(w io.Writer, pid int) error {
+	nodes, err := t.subtrees(pid)
+	if err != nil {
+		return err
+	}
+	var walk func(n *processNode, prefix string, last bool, top bool)
+	walk = func(n *processNode, prefix string, last bool, top bool) {
+		branch, next := "├─ ", "│  "
+		if last {
+			branch, next = "└─ ", "   "
+		}
+		if top {
+			branch, next = "", ""
+		}
+		owner := n.User
+		if owner == "" {
+			owner = "?"
+		}
+		fmt.Fprintf(w, "%s%s%d %s (%s, %s)\n", prefix, branch, n.PID, n.commandLine(), owner, n.State)
+		for i, child := range n.Children {
+			walk(child, prefix+next, i == len(n.Children)-1, false)
+		}
+	}
+	for _, n := range nodes {
+		walk(n, "", true, true)
+	}
+	return nil
+}
+
+// writeJSON prints the tree of pid (0 for every process) as nested objects
+func (t *processTree) writeJSON(w io.Writer, pid int) error {
+	nodes, err := t.subtrees(pid)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(nodes)
+}
+
+/*
+killTree signals pid and all its descendants. The subtree is stopped first, top
+down and until no new child shows up, so that nobody can fork or restart a killed
+child while we walk it, then every process gets sig and is continued to receive
+it. Processes already gone are not an error.
+*/
+func (fs procFS) killTree(pid int, sig syscall.Signal) error {
+	signal := func(pid int, sig syscall.Signal) error {
+		if err := syscall.Kill(pid, sig); err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("kill %d: %v", pid, err)
+		}
+		return nil
+	}
+	resume := func(pids []int) {
+		for _, pid := range pids {
+			signal(pid, syscall.SIGCONT)
+		}
+	}
+
+	var pids []int
+	seen := make(map[int]bool)
+	for {
+		t, err := fs.tree()
+		if err != nil {
+			resume(pids)
+			return err
+		}
+		descendants, err := t.descendants(pid)
+		if err != nil {
+			resume(pids)
+			return err
+		}
+		found := 0
+		for _, p := range append([]process{t.nodes[pid].process}, descendants...) {
+			if seen[p.PID] {
+				continue
+			}
+			seen[p.PID] = true
+			pids = append(pids, p.PID)
+			found++
+			if err := signal(p.PID, syscall.SIGSTOP); err != nil {
+				resume(pids)
+				return err
+			}
+		}
+		if found == 0 {
+			break
+		}
+	}
+
+	var firstErr error
+	for _, pid := range pids {
+		if err := signal(pid, sig); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if sig != syscall.SIGSTOP {
+		resume(pids)
+	}
+	return firstErr
+}
+
+// the signals worth sending to a tree, by name
+var signalNames = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"TERM": syscall.SIGTERM,
+	"CONT": syscall.SIGCONT,
+	"STOP": syscall.SIGSTOP,
+}
+
+// parseSignal accepts TERM, SIGTERM, term or the number
+func parseSignal(s string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil && n > 0 {
+		return syscall.Signal(n), nil
+	}
+	if sig, ok := signalNames[strings.TrimPrefix(strings.ToUpper(s), "SIG")]; ok {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("unknown signal %q", s)
+}
+
+// writeProcesses prints a flat list, for process groups and sessions
+func writeProcesses(w io.Writer, procs []process, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(procs)
+	}
+	for _, p := range procs {
+		fmt.Fprintf(w, "%d %s (%s, %s)\n", p.PID, p.commandLine(), p.User, p.State)
+	}
+	return nil
+}
+
+func runTree(args []string) int {
+	fs := flag.NewFlagSet("tree", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: utils tree [flags]\n\nflags:\n")
+		fs.PrintDefaults()
+	}
+	pid := fs.Int("pid", 0, "show only the tree of this process, 0 for every process")
+	pgid := fs.Int("group", 0, "list the members of this process group instead")
+	sid := fs.Int("session", 0, "list the members of this session instead")
+	asJSON := fs.Bool("json", false, "print JSON")
+	kill := fs.String("kill", "", "send this signal (TERM, KILL, 9...) to the -pid process and all its descendants")
+	fs.Parse(args)
+
+	if *kill != "" {
+		sig, err := parseSignal(*kill)
+		if err != nil {
+			log.Println(err)
+			return 2
+		}
+		if *pid <= 0 {
+			log.Println("-kill needs a -pid")
+			return 2
+		}
+		if err := defaultProcFS.killTree(*pid, sig); err != nil {
+			log.Println(err)
+			return 1
+		}
+		return 0
+	}
+
+	t, err := defaultProcFS.tree()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	switch {
+	case *pgid > 0:
+		err = writeProcesses(os.Stdout, t.processGroup(*pgid), *asJSON)
+	case *sid > 0:
+		err = writeProcesses(os.Stdout, t.session(*sid), *asJSON)
+	case *asJSON:
+		err = t.writeJSON(os.Stdout, *pid)
+	default:
+		err = t.writeText(os.Stdout, *pid)
+	}
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	return 0
+}
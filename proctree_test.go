@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestProcessTree(t *testing.T) {
+	tree, err := fakeProc(t).tree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// kworker's parent (kthreadd) is not there, so it's a root too
+	if len(tree.roots) != 2 || tree.roots[0].PID != 1 || tree.roots[1].PID != 7 {
+		t.Fatalf("roots %+v", tree.roots)
+	}
+	descendants, err := tree.descendants(1)
+	if err != nil || len(descendants) != 1 || descendants[0].PID != 42 {
+		t.Errorf("descendants of 1: %v %v", descendants, err)
+	}
+	if _, err := tree.descendants(99); err == nil {
+		t.Error("descendants of a missing process")
+	}
+	if group := tree.processGroup(42); len(group) != 1 || group[0].PID != 42 {
+		t.Errorf("group 42: %v", group)
+	}
+	if session := tree.session(1); len(session) != 1 || session[0].PID != 1 {
+		t.Errorf("session 1: %v", session)
+	}
+
+	var text bytes.Buffer
+	if err := tree.writeText(&text, 0); err != nil {
+		t.Fatal(err)
+	}
+	want := "1 /sbin/init splash (root, S)\n" +
+		"└─ 42 python3 app.py (64999, R)\n" +
+		"7 [kworker/0:1] (?, I)\n"
+	if text.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", text.String(), want)
+	}
+	if err := tree.writeText(&text, 99); err == nil {
+		t.Error("tree of a missing process")
+	}
+
+	var out bytes.Buffer
+	if err := tree.writeJSON(&out, 1); err != nil {
+		t.Fatal(err)
+	}
+	var nodes []processNode
+	if err := json.Unmarshal(out.Bytes(), &nodes); err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0].PID != 1 || len(nodes[0].Children) != 1 || nodes[0].Children[0].Comm != "my (odd) name" {
+		t.Errorf("json %s", out.String())
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	for s, want := range map[string]syscall.Signal{"TERM": syscall.SIGTERM, "sigkill": syscall.SIGKILL, "1": syscall.SIGHUP} {
+		if sig, err := parseSignal(s); err != nil || sig != want {
+			t.Errorf("%s: %v %v", s, sig, err)
+		}
+	}
+	for _, s := range []string{"", "BOGUS", "-1"} {
+		if _, err := parseSignal(s); err == nil {
+			t.Errorf("%q accepted", s)
+		}
+	}
+}
+
+func TestKillTree(t *testing.T) {
+	// a child and a grandchild, in their own process group so they don't get our signals
+	cmd := exec.Command("sh", "-c", "sleep 60 & (sleep 60; true) & wait")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+
+	var descendants []process
+	for deadline := time.Now().Add(5 * time.Second); len(descendants) < 3 && time.Now().Before(deadline); {
+		time.Sleep(20 * time.Millisecond)
+		tree, err := defaultProcFS.tree()
+		if err != nil {
+			t.Fatal(err)
+		}
+		descendants, _ = tree.descendants(cmd.Process.Pid)
+	}
+	if len(descendants) < 3 {
+		t.Fatalf("the tree didn't start: %v", descendants)
+	}
+
+	if err := defaultProcFS.killTree(cmd.Process.Pid, syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the shell survived")
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		alive := 0
+		for _, d := range descendants {
+			if p, err := defaultProcFS.process(d.PID); err == nil && p.State != "Z" && strings.Contains(p.commandLine(), "sleep") {
+				alive++
+			}
+		}
+		if alive == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d descendants still alive", alive)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+/*
+	Argon2 as described in RFC 9106.
+
+	golang.org/x/crypto/argon2 only exposes Argon2i and Argon2id, while KeePass
+	defaults to Argon2d for KDBX 4 databases, so we carry our own implementation
+	of the three variants (and of both 0x10 and 0x13 versions).
+*/
+
+const (
+	argon2d  = 0
+	argon2i  = 1
+	argon2id = 2
+
+	argon2Version10 = 0x10
+	argon2Version13 = 0x13
+
+	argon2SyncPoints  = 4
+	argon2BlockLength = 128
+)
+
+type argon2Block [argon2BlockLength]uint64
+
+type argon2Params struct {
+	mode        int
+	version     uint32
+	iterations  uint32
+	memory      uint32 // KiB
+	parallelism uint32
+	secret      []byte
+	data        []byte
+}
+
+func argon2Key(password, salt []byte, p argon2Params, keyLen uint32) []byte {
+	if p.iterations < 1 {
+		p.iterations = 1
+	}
+	if p.parallelism < 1 {
+		p.parallelism = 1
+	}
+	if p.version == 0 {
+		p.version = argon2Version13
+	}
+	h0 := argon2InitHash(password, salt, p, keyLen)
+
+	threads := p.parallelism
+	memory := p.memory / (argon2SyncPoints * threads) * (argon2SyncPoints * threads)
+	if memory < 2*argon2SyncPoints*threads {
+		memory = 2 * argon2SyncPoints * threads
+	}
+	B := argon2InitBlocks(&h0, memory, threads)
+	argon2ProcessBlocks(B, p, memory, threads)
+	return argon2ExtractKey(B, memory, threads, keyLen)
+}
+
+// H0, the pre-hashing digest of every parameter and input
+func argon2InitHash(password, salt []byte, p argon2Params, keyLen uint32) [blake2b.Size + 8]byte {
+	var h0 [blake2b.Size + 8]byte
+	b2, _ := blake2b.New512(nil)
+	writeUint32 := func(v uint32) {
+		var buf [4]byte
+		binary.LittleEndian.PutUint32(buf[:], v)
+		b2.Write(buf[:])
+	}
+	writeBytes := func(b []byte) {
+		writeUint32(uint32(len(b)))
+		b2.Write(b)
+	}
+	writeUint32(p.parallelism)
+	writeUint32(keyLen)
+	writeUint32(p.memory)
+	writeUint32(p.iterations)
+	writeUint32(p.version)
+	writeUint32(uint32(p.mode))
+	writeBytes(password)
+	writeBytes(salt)
+	writeBytes(p.secret)
+	writeBytes(p.data)
+	b2.Sum(h0[:0])
+	return h0
+}
+
+func argon2InitBlocks(h0 *[blake2b.Size + 8]byte, memory, threads uint32) []argon2Block {
+	var block0 [1024]byte
+	B := make([]argon2Block, memory)
+	for lane := uint32(0); lane < threads; lane++ {
+		j := lane * (memory / threads)
+		binary.LittleEndian.PutUint32(h0[blake2b.Size+4:], lane)
+		for i := uint32(0); i < 2; i++ {
+			binary.LittleEndian.PutUint32(h0[blake2b.Size:], i)
+			argon2Hash(block0[:], h0[:])
+			for k := range B[j+i] {
+				B[j+i][k] = binary.LittleEndian.Uint64(block0[k*8:])
+			}
+		}
+	}
+	return B
+}
+
+func argon2ProcessBlocks(B []argon2Block, p argon2Params, memory, threads uint32) {
+	lanes := memory / threads
+	segments := lanes / argon2SyncPoints
+
+	processSegment := func(n, slice, lane uint32, wg *sync.WaitGroup) {
+		defer wg.Done()
+		var addresses, in, zero argon2Block
+		dataIndependent := p.mode == argon2i || (p.mode == argon2id && n == 0 && slice < argon2SyncPoints/2)
+		if dataIndependent {
+			in[0] = uint64(n)
+			in[1] = uint64(lane)
+			in[2] = uint64(slice)
+			in[3] = uint64(memory)
+			in[4] = uint64(p.iterations)
+			in[5] = uint64(p.mode)
+		}
+
+		index := uint32(0)
+		if n == 0 && slice == 0 {
+			// the first two blocks of each lane come from argon2InitBlocks
+			index = 2
+			if dataIndependent {
+				in[6]++
+				argon2Compress(&addresses, &in, &zero, false)
+				argon2Compress(&addresses, &addresses, &zero, false)
+			}
+		}
+
+		offset := lane*lanes + slice*segments + index
+		for index < segments {
+			prev := offset - 1
+			if index == 0 && slice == 0 {
+				// last block of the lane
+				prev += lanes
+			}
+			var random uint64
+			if dataIndependent {
+				if index%argon2BlockLength == 0 {
+					in[6]++
+					argon2Compress(&addresses, &in, &zero, false)
+					argon2Compress(&addresses, &addresses, &zero, false)
+				}
+				random = addresses[index%argon2BlockLength]
+			} else {
+				random = B[prev][0]
+			}
+			ref := argon2IndexAlpha(random, lanes, segments, threads, n, slice, lane, index)
+			argon2Compress(&B[offset], &B[prev], &B[ref], n > 0 && p.version == argon2Version13)
+			index, offset = index+1, offset+1
+		}
+	}
+
+	for n := uint32(0); n < p.iterations; n++ {
+		for slice := uint32(0); slice < argon2SyncPoints; slice++ {
+			var wg sync.WaitGroup
+			for lane := uint32(0); lane < threads; lane++ {
+				wg.Add(1)
+				go processSegment(n, slice, lane, &wg)
+			}
+			wg.Wait()
+		}
+	}
+}
+
+func argon2ExtractKey(B []argon2Block, memory, threads, keyLen uint32) []byte {
+	lanes := memory / threads
+	for lane := uint32(0); lane < threads-1; lane++ {
+		for i, v := range B[(lane*lanes)+lanes-1] {
+			B[memory-1][i] ^= v
+		}
+	}
+
+	var block [1024]byte
+	for i, v := range B[memory-1] {
+		binary.LittleEndian.PutUint64(block[i*8:], v)
+	}
+	key := make([]byte, keyLen)
+	argon2Hash(key, block[:])
+	return key
+}
+
+// argon2IndexAlpha maps the pseudo-random value to the reference block
+func argon2IndexAlpha(rand uint64, lanes, segments, threads, n, slice, lane, index uint32) uint32 {
+	refLane := uint32(rand>>32) % threads
+	if n == 0 && slice == 0 {
+		refLane = lane
+	}
+	m, s := 3*segments, ((slice+1)%argon2SyncPoints)*segments
+	if lane == refLane {
+		m += index
+	}
+	if n == 0 {
+		m, s = slice*segments, 0
+		if slice == 0 || lane == refLane {
+			m += index
+		}
+	}
+	if index == 0 || lane == refLane {
+		m--
+	}
+
+	x := rand & 0xFFFFFFFF
+	x = (x * x) >> 32
+	x = (x * uint64(m)) >> 32
+	return refLane*lanes + uint32((uint64(s)+uint64(m)-(x+1))%uint64(lanes))
+}
+
+// argon2Compress is the compression function G, xor selects the 0x13 overwrite rule
+func argon2Compress(out, in1, in2 *argon2Block, xor bool) {
+	var t argon2Block
+	for i := range t {
+		t[i] = in1[i] ^ in2[i]
+	}
+	for i := 0; i < argon2BlockLength; i += 16 {
+		blamka(&t[i+0], &t[i+1], &t[i+2], &t[i+3],
+			&t[i+4], &t[i+5], &t[i+6], &t[i+7],
+			&t[i+8], &t[i+9], &t[i+10], &t[i+11],
+			&t[i+12], &t[i+13], &t[i+14], &t[i+15])
+	}
+	for i := 0; i < argon2BlockLength/8; i += 2 {
+		blamka(&t[i], &t[i+1], &t[16+i], &t[16+i+1],
+			&t[32+i], &t[32+i+1], &t[48+i], &t[48+i+1],
+			&t[64+i], &t[64+i+1], &t[80+i], &t[80+i+1],
+			&t[96+i], &t[96+i+1], &t[112+i], &t[112+i+1])
+	}
+	for i := range t {
+		if xor {
+			out[i] ^= in1[i] ^ in2[i] ^ t[i]
+		} else {
+			out[i] = in1[i] ^ in2[i] ^ t[i]
+		}
+	}
+}
+
+// blamka is the BLAKE2b round with the multiplications added by Argon2
+func blamka(t00, t01, t02, t03, t04, t05, t06, t07, t08, t09, t10, t11, t12, t13, t14, t15 *uint64) {
+	blamkaG(t00, t04, t08, t12)
+	blamkaG(t01, t05, t09, t13)
+	blamkaG(t02, t06, t10, t14)
+	blamkaG(t03, t07, t11, t15)
+	blamkaG(t00, t05, t10, t15)
+	blamkaG(t01, t06, t11, t12)
+	blamkaG(t02, t07, t08, t13)
+	blamkaG(t03, t04, t09, t14)
+}
+
+func blamkaG(a, b, c, d *uint64) {
+	fBlaMka := func(x, y uint64) uint64 {
+		return x + y + 2*uint64(uint32(x))*uint64(uint32(y))
+	}
+	*a = fBlaMka(*a, *b)
+	*d ^= *a
+	*d = *d>>32 | *d<<32
+	*c = fBlaMka(*c, *d)
+	*b ^= *c
+	*b = *b>>24 | *b<<40
+	*a = fBlaMka(*a, *b)
+	*d ^= *a
+	*d = *d>>16 | *d<<48
+	*c = fBlaMka(*c, *d)
+	*b ^= *c
+	*b = *b>>63 | *b<<1
+}
+
+// argon2Hash is the variable length hash function H'
+func argon2Hash(out []byte, in []byte) {
+	var b2 hash.Hash
+	if n := len(out); n < blake2b.Size {
+		b2, _ = blake2b.New(n, nil)
+	} else {
+		b2, _ = blake2b.New512(nil)
+	}
+
+	var buf [blake2b.Size]byte
+	binary.LittleEndian.PutUint32(buf[:4], uint32(len(out)))
+	b2.Write(buf[:4])
+	b2.Write(in)
+
+	if len(out) <= blake2b.Size {
+		b2.Sum(out[:0])
+		return
+	}
+
+	outLen := len(out)
+	b2.Sum(buf[:0])
+	b2.Reset()
+	copy(out, buf[:32])
+	out = out[32:]
+	for len(out) > blake2b.Size {
+		b2.Write(buf[:])
+		b2.Sum(buf[:0])
+		copy(out, buf[:32])
+		out = out[32:]
+		b2.Reset()
+	}
+
+	if outLen%blake2b.Size > 0 {
+		r := ((outLen + 31) / 32) - 2
+		b2, _ = blake2b.New(outLen-32*r, nil)
+	}
+	b2.Write(buf[:])
+	b2.Sum(out[:0])
+}
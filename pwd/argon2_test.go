@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// test vectors of RFC 9106, section 5
+func TestArgon2RFC9106(t *testing.T) {
+	p := argon2Params{
+		version:     argon2Version13,
+		iterations:  3,
+		memory:      32,
+		parallelism: 4,
+		secret:      bytes.Repeat([]byte{3}, 8),
+		data:        bytes.Repeat([]byte{4}, 12),
+	}
+	password := bytes.Repeat([]byte{1}, 32)
+	salt := bytes.Repeat([]byte{2}, 16)
+
+	tests := []struct {
+		mode int
+		tag  string
+	}{
+		{argon2d, "512b391b6f1162975371d30919734294f868e3be3984f3c1a13a4db9fabe4acb"},
+		{argon2i, "c814d9d1dc7f37aa13f0d77f2494bda1c8de6b016dd388d29952a4c4672b6ce8"},
+		{argon2id, "0d640df58d78766c08c037a34a8b53c9d01ef0452d75b65eb52520e96b01e659"},
+	}
+	for _, tt := range tests {
+		p.mode = tt.mode
+		if got := hex.EncodeToString(argon2Key(password, salt, p, 32)); got != tt.tag {
+			t.Errorf("mode %d: got %s, want %s", tt.mode, got, tt.tag)
+		}
+	}
+}
+
+// without secret and associated data the result must match golang.org/x/crypto
+func TestArgon2CrossCheck(t *testing.T) {
+	password, salt := []byte("password"), []byte("somesalt12345678")
+	p := argon2Params{iterations: 2, memory: 64, parallelism: 2}
+
+	p.mode = argon2i
+	if got, want := argon2Key(password, salt, p, 32), argon2.Key(password, salt, 2, 64, 2, 32); !bytes.Equal(got, want) {
+		t.Errorf("argon2i: got %x, want %x", got, want)
+	}
+	p.mode = argon2id
+	if got, want := argon2Key(password, salt, p, 32), argon2.IDKey(password, salt, 2, 64, 2, 32); !bytes.Equal(got, want) {
+		t.Errorf("argon2id: got %x, want %x", got, want)
+	}
+}
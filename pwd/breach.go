@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const pwnedPasswordsAPI = "https://api.pwnedpasswords.com"
+
+// BreachChecker answers k-anonymity queries: given the first 5 chars of a hash it
+// returns every known suffix with the number of times it was seen in a breach.
+type BreachChecker interface {
+	Range(prefix string) (map[string]int, error)
+}
+
+// contextChecker is a BreachChecker whose lookups can be cancelled, like the range API with its retries
+type contextChecker interface {
+	BreachChecker
+	RangeContext(ctx context.Context, prefix string) (map[string]int, error)
+}
+
+// rangeContext cancels the lookup with the context when the checker supports it
+func rangeContext(ctx context.Context, checker BreachChecker, prefix string) (map[string]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if c, ok := checker.(contextChecker); ok {
+		return c.RangeContext(ctx, prefix)
+	}
+	return checker.Range(prefix)
+}
+
+// pwnedCount splits the hash, asks only for its prefix and matches the suffix locally
+func pwnedCount(checker BreachChecker, hash string) (int, error) {
+	hash = strings.ToUpper(hash)
+	short := hash[:5]
+	long := hash[5:]
+	suffixes, err := checker.Range(short)
+	if err != nil {
+		return 0, err
+	}
+	return suffixes[long], nil
+}
+
+func sha1Hex(password string) string {
+	return fmt.Sprintf("%X", sha1.Sum([]byte(password)))
+}
+
+// checkerFlags are the breach source options shared by every command
+type checkerFlags struct {
+	mode     string
+	dataset  string
+	api      string
+	workers  int
+	rate     float64
+	cacheDir string
+	cacheTTL time.Duration
+}
+
+func (c *checkerFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.mode, "mode", hashSHA1, "hash function: sha1, or ntlm for NT hashes (range API ?mode=ntlm and NTLM dataset)")
+	fs.StringVar(&c.dataset, "dataset", "", "offline Pwned Passwords dataset (ordered by hash text file or directory of range files)")
+	fs.StringVar(&c.api, "api", pwnedPasswordsAPI, "base URL of the range API, or of a mirror")
+	fs.IntVar(&c.workers, "workers", 4, "concurrent range lookups")
+	fs.Float64Var(&c.rate, "rate", 10, "maximum range API requests per second (0 for no limit)")
+	fs.StringVar(&c.cacheDir, "cache", "", "keep range API responses in this directory")
+	fs.DurationVar(&c.cacheTTL, "cache-ttl", 24*time.Hour, "how long cached range responses are valid")
+}
+
+// open returns the BreachChecker described by the flags, the function releases it
+func (c *checkerFlags) open() (BreachChecker, func(), error) {
+	if c.mode != hashSHA1 && c.mode != hashNTLM {
+		return nil, nil, fmt.Errorf("unknown mode %q, use sha1 or ntlm", c.mode)
+	}
+	if c.dataset != "" {
+		dataset, err := openDataset(c.dataset)
+		if err != nil {
+			return nil, nil, err
+		}
+		return dataset, func() { dataset.Close() }, nil
+	}
+	api := newRangeAPI(c.api)
+	api.mode = c.mode
+	api.limiter = newTokenBucket(c.rate, c.workers)
+	if c.cacheDir == "" {
+		return api, func() {}, nil
+	}
+	// SHA-1 and NT hashes never share a cache directory
+	cached, err := newCachedChecker(api, filepath.Join(c.cacheDir, c.mode), c.cacheTTL)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cached, func() {}, nil
+}
+
+// rangeAPI is the live Pwned Passwords API, or any mirror speaking the same protocol
+type rangeAPI struct {
+	baseURL string
+	mode    string // empty or sha1 for SHA-1, ntlm for NT hashes
+	padding bool
+	client  *http.Client
+	limiter *tokenBucket
+	retries int
+	backoff time.Duration
+}
+
+func newRangeAPI(baseURL string) *rangeAPI {
+	if baseURL == "" {
+		baseURL = pwnedPasswordsAPI
+	}
+	return &rangeAPI{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		padding: true,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		retries: 5,
+		backoff: time.Second,
+	}
+}
+
+func (a *rangeAPI) Range(prefix string) (map[string]int, error) {
+	return a.RangeContext(context.Background(), prefix)
+}
+
+// RangeContext stops waiting for the rate limit, the response or the next retry when the context is done
+func (a *rangeAPI) RangeContext(ctx context.Context, prefix string) (map[string]int, error) {
+	for attempt := 0; ; attempt++ {
+		if err := a.limiter.wait(ctx); err != nil {
+			return nil, err
+		}
+		suffixes, retryAfter, err := a.fetch(ctx, prefix)
+		if err == nil || retryAfter < 0 || attempt >= a.retries {
+			return suffixes, err
+		}
+		// exponential backoff, unless the server told us how long to wait
+		wait := a.backoff << uint(attempt)
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// fetch does a single request, retryAfter < 0 means that retrying is pointless
+func (a *rangeAPI) fetch(ctx context.Context, prefix string) (map[string]int, time.Duration, error) {
+	url := a.baseURL + "/range/" + prefix
+	if a.mode == hashNTLM {
+		url += "?mode=ntlm"
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, -1, err
+	}
+	if a.padding {
+		req.Header.Set("Add-Padding", "true")
+	}
+	res, err := a.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, -1, ctx.Err()
+		}
+		// network errors are usually transient
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500 {
+		retryAfter := time.Duration(0)
+		if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		return nil, retryAfter, fmt.Errorf("range %s: %s", prefix, res.Status)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, -1, fmt.Errorf("range %s: %s", prefix, res.Status)
+	}
+	suffixes, err := parseRange(res.Body, prefix)
+	if err != nil {
+		return nil, 0, err
+	}
+	// padding entries are fake suffixes with count 0
+	for suffix, num := range suffixes {
+		if num == 0 {
+			delete(suffixes, suffix)
+		}
+	}
+	return suffixes, 0, nil
+}
+
+/*
+rangeServer is a stand-in for the range API, backed by any other BreachChecker.
+It speaks the same protocol: GET /range/ABCDE returns SUFFIX:COUNT lines separated
+by CRLF and, when asked with "Add-Padding: true", adds random suffixes with count 0
+so that every response has about the same size.
+*/
+type rangeServer struct {
+	source     BreachChecker
+	mode       string // of the hashes of source, ?mode=ntlm asks for NT hashes
+	padding    bool
+	minEntries int
+}
+
+func newRangeServer(mode string, source BreachChecker) *rangeServer {
+	return &rangeServer{source: source, mode: mode, minEntries: 800}
+}
+
+func (s *rangeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	prefix := strings.ToUpper(strings.TrimPrefix(r.URL.Path, "/range/"))
+	if r.Method != http.MethodGet || !isHashPrefix(prefix) {
+		http.Error(w, "The hash prefix was not in a valid format", http.StatusBadRequest)
+		return
+	}
+	// SHA-1 without the parameter, like the real API
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = hashSHA1
+	}
+	if mode != s.mode {
+		http.Error(w, fmt.Sprintf("This server only has %s hashes", s.mode), http.StatusBadRequest)
+		return
+	}
+	suffixes, err := s.source.Range(prefix)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	lines := make([]string, 0, len(suffixes))
+	for suffix, num := range suffixes {
+		lines = append(lines, fmt.Sprintf("%s:%d", suffix, num))
+	}
+	if s.padding || r.Header.Get("Add-Padding") == "true" {
+		lines = append(lines, padding(s.minEntries-len(lines), suffixLength(suffixes, mode))...)
+	}
+	sort.Strings(lines)
+
+	w.Header().Set("Content-Type", "text/plain")
+	io.WriteString(w, strings.Join(lines, "\r\n"))
+}
+
+// padding returns n random suffixes with count 0
+func padding(n int, length int) []string {
+	var lines []string
+	buf := make([]byte, (length+1)/2)
+	for i := 0; i < n; i++ {
+		rand.Read(buf)
+		lines = append(lines, strings.ToUpper(hex.EncodeToString(buf))[:length]+":0")
+	}
+	return lines
+}
+
+func suffixLength(suffixes map[string]int, mode string) int {
+	for suffix := range suffixes {
+		return len(suffix)
+	}
+	// NT hash 32 chars, SHA-1 40 chars, minus the prefix
+	if mode == hashNTLM {
+		return 27
+	}
+	return 35
+}
+
+func isHashPrefix(prefix string) bool {
+	if len(prefix) != 5 {
+		return false
+	}
+	_, err := hex.DecodeString(prefix + "0")
+	return err == nil
+}
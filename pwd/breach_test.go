@@ -0,0 +1,186 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// staticChecker is an in memory dataset: full hash -> count
+type staticChecker map[string]int
+
+func newStaticChecker(mode string, passwords map[string]int) staticChecker {
+	checker := make(staticChecker)
+	for password, num := range passwords {
+		checker[hashPassword(mode, password)] = num
+	}
+	return checker
+}
+
+func (s staticChecker) Range(prefix string) (map[string]int, error) {
+	prefix = strings.ToUpper(prefix)
+	suffixes := make(map[string]int)
+	for hash, num := range s {
+		if strings.HasPrefix(hash, prefix) {
+			suffixes[hash[len(prefix):]] = num
+		}
+	}
+	return suffixes, nil
+}
+
+// newFakeRangeServer starts an in-process server, point newRangeAPI to its URL
+func newFakeRangeServer(mode string, source BreachChecker) *httptest.Server {
+	return httptest.NewServer(newRangeServer(mode, source))
+}
+
+func TestRangeAPI(t *testing.T) {
+	server := newFakeRangeServer(hashSHA1, newStaticChecker(hashSHA1, map[string]int{"password": 42, "hunter2": 7}))
+	defer server.Close()
+	api := newRangeAPI(server.URL)
+
+	for password, want := range map[string]int{"password": 42, "hunter2": 7, "correct horse battery staple": 0} {
+		num, err := pwnedCount(api, sha1Hex(password))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if num != want {
+			t.Errorf("%s: got %d, want %d", password, num, want)
+		}
+	}
+}
+
+func TestRangeAPINTLM(t *testing.T) {
+	server := newFakeRangeServer(hashNTLM, newStaticChecker(hashNTLM, map[string]int{"password": 42}))
+	defer server.Close()
+	api := newRangeAPI(server.URL)
+	api.mode = hashNTLM
+
+	num, err := pwnedCount(api, ntHash("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if num != 42 {
+		t.Errorf("got %d, want 42", num)
+	}
+	suffixes, err := api.Range(ntHash("password")[:5])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for suffix := range suffixes {
+		if len(suffix) != 27 {
+			t.Errorf("suffix %q is not the rest of an NT hash", suffix)
+		}
+	}
+
+	// a SHA-1 lookup on an NTLM server is an error, not "not found"
+	api.mode = hashSHA1
+	api.retries = 0
+	if _, err := pwnedCount(api, sha1Hex("password")); err == nil {
+		t.Error("SHA-1 lookup answered by an NTLM server")
+	}
+}
+
+func TestRangeServerPadding(t *testing.T) {
+	checker := newStaticChecker(hashSHA1, map[string]int{"password": 42})
+	prefix := sha1Hex("password")[:5]
+	server := newRangeServer(hashSHA1, checker)
+
+	get := func(padded bool) []string {
+		req := httptest.NewRequest("GET", "/range/"+prefix, nil)
+		if padded {
+			req.Header.Set("Add-Padding", "true")
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d", w.Code)
+		}
+		return strings.Split(w.Body.String(), "\r\n")
+	}
+
+	if lines := get(false); len(lines) != 1 {
+		t.Errorf("without padding: %d lines", len(lines))
+	}
+	lines := get(true)
+	if len(lines) < server.minEntries {
+		t.Errorf("with padding: %d lines, want at least %d", len(lines), server.minEntries)
+	}
+	for _, line := range lines {
+		if len(line) != 35+2 && !strings.HasSuffix(line, ":42") {
+			t.Errorf("malformed line %q", line)
+		}
+	}
+
+	// the client drops the padding
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	suffixes, err := newRangeAPI(ts.URL).Range(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(suffixes) != 1 {
+		t.Errorf("padding not removed: %d suffixes", len(suffixes))
+	}
+}
+
+func TestRangeServerBadPrefix(t *testing.T) {
+	w := httptest.NewRecorder()
+	newRangeServer(hashSHA1, staticChecker{}).ServeHTTP(w, httptest.NewRequest("GET", "/range/XYZ", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", w.Code)
+	}
+}
+
+// flakyServer answers with status for the first failures requests, then delegates
+func flakyServer(failures int32, status int, next http.Handler) (*httptest.Server, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= failures {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+	return server, &requests
+}
+
+func TestRangeAPIRetry(t *testing.T) {
+	source := newRangeServer(hashSHA1, newStaticChecker(hashSHA1, map[string]int{"password": 42}))
+	hash := sha1Hex("password")
+
+	tests := []struct {
+		name     string
+		failures int32
+		status   int
+		retries  int
+		requests int32
+		fails    bool
+	}{
+		{"too many requests", 2, http.StatusTooManyRequests, 5, 3, false},
+		{"server error", 1, http.StatusServiceUnavailable, 5, 2, false},
+		{"retries exhausted", 10, http.StatusTooManyRequests, 2, 3, true},
+		{"not retried", 1, http.StatusBadRequest, 5, 1, true},
+	}
+	for _, test := range tests {
+		server, requests := flakyServer(test.failures, test.status, source)
+		api := newRangeAPI(server.URL)
+		api.retries = test.retries
+		api.backoff = time.Millisecond
+		num, err := pwnedCount(api, hash)
+		server.Close()
+
+		if test.fails != (err != nil) {
+			t.Errorf("%s: error %v", test.name, err)
+		}
+		if !test.fails && num != 42 {
+			t.Errorf("%s: got %d, want 42", test.name, num)
+		}
+		if *requests != test.requests {
+			t.Errorf("%s: %d requests, want %d", test.name, *requests, test.requests)
+		}
+	}
+}
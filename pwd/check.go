@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+/*
+	pwd check: k-anonymity lookup of single passwords.
+
+	On a terminal the password (or its hash, with -hashed) is asked without echo,
+	otherwise passwords are read one per line from stdin. Only the line number and
+	the breach count are printed, never the password, so that it can be used in
+	pipelines and git hooks:
+
+		echo "$candidate" | pwd check -quiet || echo "pick another one"
+*/
+
+// passwords are checked in batches, so that identical prefixes are fetched once
+const checkBatch = 1000
+
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: pwd check [flags] < passwords\n\nflags:\n")
+		fs.PrintDefaults()
+	}
+	var source checkerFlags
+	source.register(fs)
+	quiet := fs.Bool("quiet", false, "print nothing, only set the exit code")
+	hashed := fs.Bool("hashed", false, "stdin has hex hashes (SHA-1, or NT hashes with -mode ntlm) instead of passwords")
+	fs.Parse(args)
+
+	checker, closeChecker, err := source.open()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	defer closeChecker()
+
+	hash := func(line string) (string, error) {
+		if *hashed {
+			hash := strings.ToUpper(strings.TrimSpace(line))
+			return hash, validHash(source.mode, hash)
+		}
+		return hashPassword(source.mode, line), nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		if *hashed {
+			fmt.Fprint(os.Stderr, "Hash: ")
+		} else {
+			fmt.Fprint(os.Stderr, "Password: ")
+		}
+		password, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		h, err := hash(string(password))
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		num, err := pwnedCount(checker, h)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		if !*quiet {
+			if num > 0 {
+				fmt.Printf("pwned %d times\n", num)
+			} else {
+				fmt.Println("not found")
+			}
+		}
+		if num > 0 {
+			return exitPwned
+		}
+		return 0
+	}
+
+	invalid := 0
+	pwned, err := checkStream(os.Stdin, checker, hash, source.workers, func(line int, num int) {
+		if !*quiet {
+			fmt.Printf("%d:%d\n", line, num)
+		}
+	}, func(line int, err error) {
+		invalid++
+		log.Printf("line %d: %v, skipped", line, err)
+	})
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	if pwned {
+		return exitPwned
+	}
+	if invalid > 0 {
+		// not checked is not the same as not found
+		return 1
+	}
+	return 0
+}
+
+// validHash tells if the text is a whole hash of the mode, 40 hex digits for SHA-1 or 32 for NT
+func validHash(mode string, hash string) error {
+	length, name := 40, "SHA-1"
+	if mode == hashNTLM {
+		length, name = 32, "NT"
+	}
+	if _, err := hex.DecodeString(hash); err != nil || len(hash) != length {
+		return fmt.Errorf("not a %s hash (%d hex digits)", name, length)
+	}
+	return nil
+}
+
+/*
+checkStream checks newline separated passwords, report gets the line number and
+the count. The lines hash refuses are not looked up, invalid gets them with the
+reason.
+*/
+func checkStream(r io.Reader, checker BreachChecker, hash func(string) (string, error), workers int, report func(line int, num int), invalid func(line int, err error)) (bool, error) {
+	pwned := false
+	scanner := bufio.NewScanner(r)
+	line := 0
+	var hashes []string
+	var lines []int
+	flush := func() error {
+		if len(hashes) == 0 {
+			return nil
+		}
+		counts, err := lookupAll(checker, hashes, workers)
+		if err != nil {
+			return err
+		}
+		for i, num := range counts {
+			if num > 0 {
+				pwned = true
+			}
+			report(lines[i], num)
+		}
+		hashes, lines = hashes[:0], lines[:0]
+		return nil
+	}
+	for scanner.Scan() {
+		line++
+		// only the hash is kept around
+		h, err := hash(strings.TrimRight(scanner.Text(), "\r"))
+		if err != nil {
+			invalid(line, err)
+			continue
+		}
+		hashes = append(hashes, h)
+		lines = append(lines, line)
+		if len(hashes) == checkBatch {
+			if err := flush(); err != nil {
+				return pwned, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return pwned, err
+	}
+	return pwned, flush()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckStreamHashed(t *testing.T) {
+	checker := newStaticChecker(hashSHA1, map[string]int{"password": 42})
+	input := strings.Join([]string{
+		"ABCDEF0123",
+		"",
+		sha1Hex("password"),
+		strings.Repeat("Z", 40),
+		strings.ToLower(sha1Hex("not breached")),
+		ntHash("password"),
+	}, "\n") + "\n"
+
+	hash := func(line string) (string, error) {
+		hash := strings.ToUpper(strings.TrimSpace(line))
+		return hash, validHash(hashSHA1, hash)
+	}
+	counts := make(map[int]int)
+	var invalid []int
+	pwned, err := checkStream(strings.NewReader(input), checker, hash, 2, func(line int, num int) {
+		counts[line] = num
+	}, func(line int, err error) {
+		invalid = append(invalid, line)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pwned {
+		t.Error("not pwned")
+	}
+	if len(counts) != 2 || counts[3] != 42 || counts[5] != 0 {
+		t.Errorf("counts %v, want line 3: 42 and line 5: 0", counts)
+	}
+	want := []int{1, 2, 4, 6}
+	if len(invalid) != len(want) {
+		t.Fatalf("invalid lines %v, want %v", invalid, want)
+	}
+	for i := range want {
+		if invalid[i] != want[i] {
+			t.Fatalf("invalid lines %v, want %v", invalid, want)
+		}
+	}
+}
+
+func TestValidHash(t *testing.T) {
+	tests := []struct {
+		mode, hash string
+		valid      bool
+	}{
+		{hashSHA1, sha1Hex("x"), true},
+		{hashSHA1, ntHash("x"), false},
+		{hashNTLM, ntHash("x"), true},
+		{hashNTLM, sha1Hex("x"), false},
+		{hashSHA1, "", false},
+		{hashSHA1, "ABCDE", false},
+		{hashNTLM, strings.Repeat("G", 32), false},
+	}
+	for _, test := range tests {
+		if err := validHash(test.mode, test.hash); (err == nil) != test.valid {
+			t.Errorf("%s %q: %v", test.mode, test.hash, err)
+		}
+	}
+}
+
+func TestLookupAllShortHash(t *testing.T) {
+	if _, err := lookupAll(staticChecker{}, []string{""}, 1); err == nil {
+		t.Error("empty hash accepted")
+	}
+}
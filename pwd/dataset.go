@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/*
+Offline copy of the Pwned Passwords dataset.
+
+Two layouts are supported:
+ 1. the single "ordered by hash" text file, one HASH:COUNT per line, sorted by hash.
+    We never load it in memory: each lookup is a binary search on the byte offsets.
+ 2. a directory of range files, one per 5 chars prefix (ABCDE.txt or ABCDE), each one
+    containing the same SUFFIX:COUNT lines returned by the range API.
+*/
+type localDataset struct {
+	path string
+	file *os.File
+	size int64
+}
+
+func openDataset(path string) (*localDataset, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	dataset := &localDataset{path: path}
+	if info.IsDir() {
+		return dataset, nil
+	}
+	dataset.file, err = os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	dataset.size = info.Size()
+	return dataset, nil
+}
+
+func (d *localDataset) Close() error {
+	if d.file != nil {
+		return d.file.Close()
+	}
+	return nil
+}
+
+// Range answers the same question as the range API: all the known suffixes for a prefix
+func (d *localDataset) Range(prefix string) (map[string]int, error) {
+	prefix = strings.ToUpper(prefix)
+	if d.file == nil {
+		return d.rangeFromDir(prefix)
+	}
+	return d.rangeFromFile(prefix)
+}
+
+func (d *localDataset) rangeFromDir(prefix string) (map[string]int, error) {
+	rangeFile, err := os.Open(filepath.Join(d.path, prefix+".txt"))
+	if os.IsNotExist(err) {
+		rangeFile, err = os.Open(filepath.Join(d.path, prefix))
+	}
+	if os.IsNotExist(err) {
+		// no file, no breached hash with this prefix
+		return map[string]int{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rangeFile.Close()
+	return parseRange(rangeFile, prefix)
+}
+
+func (d *localDataset) rangeFromFile(prefix string) (map[string]int, error) {
+	// find the first line whose prefix is not lower than the one we are looking for
+	lo, hi := int64(0), d.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		line, _, err := d.lineAt(mid)
+		if err != nil {
+			return nil, err
+		}
+		if line == "" || len(line) < 5 || strings.ToUpper(line[:5]) >= prefix {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	_, start, err := d.lineAt(lo)
+	if err != nil {
+		return nil, err
+	}
+
+	// then read forward while the lines share the prefix
+	suffixes := make(map[string]int)
+	scanner := bufio.NewScanner(io.NewSectionReader(d.file, start, d.size-start))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(strings.ToUpper(line), prefix) {
+			break
+		}
+		hash, num, ok := splitRangeLine(line)
+		if ok {
+			suffixes[hash[5:]] = num
+		}
+	}
+	return suffixes, scanner.Err()
+}
+
+// lineAt returns the first complete line starting at or after off and its offset
+func (d *localDataset) lineAt(off int64) (string, int64, error) {
+	// a line is "SHA1:COUNT\r\n", 256 bytes are enough for the partial line and the next one
+	buf := make([]byte, 256)
+	start := off
+	if off > 0 {
+		start = off - 1
+	}
+	n, err := d.file.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return "", d.size, err
+	}
+	buf = buf[:n]
+	if off > 0 {
+		// skip the tail of the line we landed in
+		i := strings.IndexByte(string(buf), '\n')
+		if i < 0 {
+			return "", d.size, nil
+		}
+		buf = buf[i+1:]
+		start += int64(i + 1)
+	}
+	if len(buf) == 0 {
+		return "", d.size, nil
+	}
+	line := string(buf)
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	return strings.TrimSpace(line), start, nil
+}
+
+// parseRange reads SUFFIX:COUNT lines, accepting full hashes too
+func parseRange(r io.Reader, prefix string) (map[string]int, error) {
+	suffixes := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		hash, num, ok := splitRangeLine(strings.TrimSpace(scanner.Text()))
+		if !ok {
+			continue
+		}
+		// full SHA-1 (40) or NTLM (32) hashes instead of suffixes
+		if strings.HasPrefix(hash, prefix) && (len(hash) == 40 || len(hash) == 32) {
+			hash = hash[len(prefix):]
+		}
+		suffixes[hash] = num
+	}
+	return suffixes, scanner.Err()
+}
+
+func splitRangeLine(line string) (string, int, bool) {
+	spl := strings.Split(line, ":")
+	if len(spl) != 2 {
+		return "", 0, false
+	}
+	num, err := strconv.Atoi(spl[1])
+	if err != nil {
+		return "", 0, false
+	}
+	return strings.ToUpper(spl[0]), num, true
+}
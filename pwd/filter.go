@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// stringList is a flag that can be repeated
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+type entryFilter struct {
+	include        []string
+	exclude        []string
+	skipRecycleBin bool
+}
+
+// matchGroup is true when the group, or one of its parents, is pattern or matches it as a glob
+func matchGroup(pattern string, group string) bool {
+	pattern = strings.Trim(pattern, "/")
+	for prefix := group; ; {
+		if matched, _ := path.Match(pattern, prefix); matched || prefix == pattern {
+			return true
+		}
+		i := strings.LastIndex(prefix, "/")
+		if i < 0 {
+			return false
+		}
+		prefix = prefix[:i]
+	}
+}
+
+func (f entryFilter) keep(v data) bool {
+	if f.skipRecycleBin && v.InRecycleBin {
+		return false
+	}
+	for _, pattern := range f.exclude {
+		if matchGroup(pattern, v.Group) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, pattern := range f.include {
+		if matchGroup(pattern, v.Group) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f entryFilter) apply(informazioni []data) []data {
+	var kept []data
+	for _, v := range informazioni {
+		if f.keep(v) {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,66 @@
+package main
+
+import "testing"
+
+func TestMatchGroup(t *testing.T) {
+	tests := []struct {
+		pattern, group string
+		want           bool
+	}{
+		{"Root/Work", "Root/Work", true},
+		{"Root/Work/", "Root/Work/A", true},
+		{"Root/Work", "Root/Workshop", false},
+		{"Root/Work", "Root", false},
+		{"Root/*", "Root/Work", true},
+		{"Root/Work/*", "Root/Work/A/B", true},
+		{"Root/Work/*", "Root/Work", false},
+		{"Root/*/Servers", "Root/Work/Servers/Linux", true},
+		{"Root/*/Servers", "Root/Work/Desktops", false},
+		{"*", "Root/Work", true},
+		{"Work", "Root/Work", false},
+	}
+	for _, tt := range tests {
+		if got := matchGroup(tt.pattern, tt.group); got != tt.want {
+			t.Errorf("matchGroup(%q, %q) = %v, want %v", tt.pattern, tt.group, got, tt.want)
+		}
+	}
+}
+
+func TestEntryFilter(t *testing.T) {
+	informazioni := []data{
+		{Account: "mail", Group: "Root"},
+		{Account: "vpn", Group: "Root/Work"},
+		{Account: "db", Group: "Root/Work/Servers"},
+		{Account: "old", Group: "Root/Recycle Bin", InRecycleBin: true},
+	}
+	tests := []struct {
+		name   string
+		filter entryFilter
+		want   []string
+	}{
+		{"none", entryFilter{}, []string{"mail", "vpn", "db", "old"}},
+		{"include", entryFilter{include: []string{"Root/Work"}}, []string{"vpn", "db"}},
+		{"include glob", entryFilter{include: []string{"Root/W*"}}, []string{"vpn", "db"}},
+		{"exclude", entryFilter{exclude: []string{"Root/Work/Servers"}}, []string{"mail", "vpn", "old"}},
+		{"exclude wins", entryFilter{include: []string{"Root/Work"}, exclude: []string{"Root/*/Servers"}}, []string{"vpn"}},
+		{"recycle bin", entryFilter{skipRecycleBin: true}, []string{"mail", "vpn", "db"}},
+		{"include recycle bin", entryFilter{include: []string{"Root/Recycle Bin"}, skipRecycleBin: true}, nil},
+	}
+	for _, tt := range tests {
+		kept := tt.filter.apply(informazioni)
+		var got []string
+		for _, v := range kept {
+			got = append(got, v.Account)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
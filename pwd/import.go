@@ -0,0 +1,523 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// importer turns a password manager database or export into data records
+type importer interface {
+	name() string
+	// detect looks at the path and at the first bytes of the file (nil for directories)
+	detect(path string, head []byte) bool
+	load(path string) ([]data, error)
+}
+
+// selectImporter picks the importer by name, or the first one recognising the file when format is auto
+func selectImporter(format string, path string, keyFile string, opts parseOptions) (importer, error) {
+	importers := []importer{
+		&keepassImporter{keyFile: keyFile, opts: opts},
+		bitwardenImporter{opts: opts},
+		onePUXImporter{opts: opts},
+		passImporter{},
+		secretsdumpImporter{opts: opts},
+		csvImporter{},
+	}
+	if format != "auto" {
+		for _, imp := range importers {
+			if imp.name() == format {
+				return imp, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown import format %q", format)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	var head []byte
+	if !info.IsDir() {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		head = make([]byte, 4096)
+		n, _ := io.ReadFull(f, head)
+		head = head[:n]
+		f.Close()
+	}
+	for _, imp := range importers {
+		if imp.detect(path, head) {
+			return imp, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: unknown database format, use -import", path)
+}
+
+// KeePass: KDBX database or XML export
+
+type keepassImporter struct {
+	keyFile string
+	opts    parseOptions
+	file    *keepassFile // set by load
+}
+
+func (keepassImporter) name() string { return "keepass" }
+
+func (keepassImporter) detect(path string, head []byte) bool {
+	return isKDBX(head) || bytes.Contains(head, []byte("<KeePassFile"))
+}
+
+func (k *keepassImporter) load(path string) ([]data, error) {
+	informazioni, file, err := loadKeePass(path, k.keyFile, k.opts)
+	k.file = file
+	return informazioni, err
+}
+
+// Bitwarden: unencrypted JSON export
+
+type bitwardenExport struct {
+	Encrypted bool `json:"encrypted"`
+	Folders   []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"folders"`
+	Items []struct {
+		ID           string    `json:"id"`
+		FolderID     string    `json:"folderId"`
+		Type         int       `json:"type"`
+		Name         string    `json:"name"`
+		Notes        string    `json:"notes"`
+		RevisionDate time.Time `json:"revisionDate"`
+		DeletedDate  time.Time `json:"deletedDate"`
+		Login        struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			URIs     []struct {
+				URI string `json:"uri"`
+			} `json:"uris"`
+		} `json:"login"`
+		Fields []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+			Type  int    `json:"type"` // 1 hidden
+		} `json:"fields"`
+		PasswordHistory []struct {
+			Password     string    `json:"password"`
+			LastUsedDate time.Time `json:"lastUsedDate"`
+		} `json:"passwordHistory"`
+	} `json:"items"`
+}
+
+type bitwardenImporter struct {
+	opts parseOptions
+}
+
+func (bitwardenImporter) name() string { return "bitwarden" }
+
+func (bitwardenImporter) detect(path string, head []byte) bool {
+	head = bytes.TrimSpace(head)
+	return bytes.HasPrefix(head, []byte("{")) &&
+		(bytes.Contains(head, []byte(`"items"`)) || bytes.Contains(head, []byte(`"encrypted"`)))
+}
+
+func (b bitwardenImporter) load(path string) ([]data, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var export bitwardenExport
+	if err := json.Unmarshal(content, &export); err != nil {
+		return nil, err
+	}
+	if export.Encrypted {
+		return nil, errors.New("encrypted Bitwarden exports are not supported, export as unencrypted JSON")
+	}
+	folders := make(map[string]string)
+	for _, f := range export.Folders {
+		folders[f.ID] = f.Name
+	}
+
+	var informazioni []data
+	for _, item := range export.Items {
+		// 1 is the login type, cards, identities and notes have no password
+		if item.Type != 1 {
+			continue
+		}
+		informazione := data{
+			UUID:         item.ID,
+			Account:      item.Name,
+			Username:     item.Login.Username,
+			Password:     item.Login.Password,
+			Notes:        item.Notes,
+			Group:        "Bitwarden",
+			Modified:     item.RevisionDate,
+			InRecycleBin: !item.DeletedDate.IsZero(),
+		}
+		if folder, ok := folders[item.FolderID]; ok {
+			informazione.Group += "/" + folder
+		}
+		if len(item.Login.URIs) > 0 {
+			informazione.URL = item.Login.URIs[0].URI
+		}
+		informazioni = append(informazioni, informazione)
+
+		if b.opts.fields {
+			for _, f := range item.Fields {
+				if f.Type == 1 && f.Value != "" {
+					field := informazione
+					field.Field = f.Name
+					field.Password = f.Value
+					informazioni = append(informazioni, field)
+				}
+			}
+		}
+		if b.opts.history {
+			// Bitwarden lists the most recent password first
+			for h, old := range item.PasswordHistory {
+				revision := informazione
+				revision.Password = old.Password
+				revision.Modified = old.LastUsedDate
+				revision.Revision = h + 1
+				informazioni = append(informazioni, revision)
+			}
+		}
+	}
+	return informazioni, nil
+}
+
+// 1Password: 1PUX export, a zip archive with an export.data JSON file
+
+type onePUXItem struct {
+	UUID      string `json:"uuid"`
+	UpdatedAt int64  `json:"updatedAt"`
+	State     string `json:"state"`
+	Overview  struct {
+		Title string   `json:"title"`
+		URL   string   `json:"url"`
+		Tags  []string `json:"tags"`
+	} `json:"overview"`
+	Details struct {
+		LoginFields []struct {
+			Value       string `json:"value"`
+			Designation string `json:"designation"`
+		} `json:"loginFields"`
+		NotesPlain string `json:"notesPlain"`
+		Password   string `json:"password"`
+		Sections   []struct {
+			Fields []struct {
+				Title string `json:"title"`
+				Value struct {
+					Concealed string `json:"concealed"`
+				} `json:"value"`
+			} `json:"fields"`
+		} `json:"sections"`
+		PasswordHistory []struct {
+			Value string `json:"value"`
+			Time  int64  `json:"time"`
+		} `json:"passwordHistory"`
+	} `json:"details"`
+}
+
+type onePUXExport struct {
+	Accounts []struct {
+		Attrs struct {
+			Name string `json:"accountName"`
+		} `json:"attrs"`
+		Vaults []struct {
+			Attrs struct {
+				Name string `json:"name"`
+			} `json:"attrs"`
+			Items []onePUXItem `json:"items"`
+		} `json:"vaults"`
+	} `json:"accounts"`
+}
+
+type onePUXImporter struct {
+	opts parseOptions
+}
+
+func (onePUXImporter) name() string { return "1pux" }
+
+func (onePUXImporter) detect(path string, head []byte) bool {
+	if !bytes.HasPrefix(head, []byte("PK\x03\x04")) {
+		return false
+	}
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return false
+	}
+	defer archive.Close()
+	for _, f := range archive.File {
+		if f.Name == "export.data" {
+			return true
+		}
+	}
+	return false
+}
+
+func (o onePUXImporter) load(path string) ([]data, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+	var export onePUXExport
+	found := false
+	for _, f := range archive.File {
+		if f.Name != "export.data" {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		err = json.NewDecoder(r).Decode(&export)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		found = true
+	}
+	if !found {
+		return nil, errors.New("export.data not found in the 1PUX archive")
+	}
+
+	var informazioni []data
+	for _, account := range export.Accounts {
+		for _, vault := range account.Vaults {
+			group := "1Password/" + vault.Attrs.Name
+			for _, item := range vault.Items {
+				informazioni = append(informazioni, o.parseItem(item, group)...)
+			}
+		}
+	}
+	return informazioni, nil
+}
+
+func (o onePUXImporter) parseItem(item onePUXItem, group string) []data {
+	informazione := data{
+		UUID:         item.UUID,
+		Account:      item.Overview.Title,
+		URL:          item.Overview.URL,
+		Tags:         item.Overview.Tags,
+		Notes:        item.Details.NotesPlain,
+		Group:        group,
+		Password:     item.Details.Password,
+		InRecycleBin: item.State == "archived",
+	}
+	if item.UpdatedAt > 0 {
+		informazione.Modified = time.Unix(item.UpdatedAt, 0)
+	}
+	for _, f := range item.Details.LoginFields {
+		switch f.Designation {
+		case "username":
+			informazione.Username = f.Value
+		case "password":
+			informazione.Password = f.Value
+		}
+	}
+	informazioni := []data{informazione}
+
+	if o.opts.fields {
+		for _, section := range item.Details.Sections {
+			for _, f := range section.Fields {
+				if f.Value.Concealed != "" {
+					field := informazione
+					field.Field = f.Title
+					field.Password = f.Value.Concealed
+					informazioni = append(informazioni, field)
+				}
+			}
+		}
+	}
+	if o.opts.history {
+		for h, old := range item.Details.PasswordHistory {
+			revision := informazione
+			revision.Password = old.Value
+			revision.Modified = time.Unix(old.Time, 0)
+			revision.Revision = h + 1
+			informazioni = append(informazioni, revision)
+		}
+	}
+	return informazioni
+}
+
+// CSV: browser (Chrome, Firefox, Edge...), 1Password, Bitwarden and LastPass CSV exports
+
+// csvColumns maps our fields to the header names used by the different exports
+var csvColumns = map[string][]string{
+	"title":    {"name", "title", "account"},
+	"url":      {"url", "website", "login_uri", "origin"},
+	"username": {"username", "login_username", "user name", "login"},
+	"password": {"password", "login_password"},
+	"notes":    {"note", "notes", "extra"},
+	"group":    {"folder", "grouping", "group"},
+	"uuid":     {"guid", "uuid", "id"},
+	"modified": {"timepasswordchanged"},
+}
+
+type csvImporter struct{}
+
+func (csvImporter) name() string { return "csv" }
+
+func (csvImporter) detect(path string, head []byte) bool {
+	line, _, _ := bufio.NewReader(bytes.NewReader(head)).ReadLine()
+	header := strings.ToLower(string(line))
+	return strings.Contains(header, "password") && strings.Contains(header, ",")
+}
+
+func (csvImporter) load(path string) ([]data, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for field, names := range csvColumns {
+		columns[field] = -1
+		for i, h := range header {
+			h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\uFEFF")))
+			for _, name := range names {
+				if h == name && columns[field] < 0 {
+					columns[field] = i
+				}
+			}
+		}
+	}
+	if columns["password"] < 0 {
+		return nil, errors.New("no password column in the CSV header")
+	}
+
+	var informazioni []data
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		get := func(field string) string {
+			if i := columns[field]; i >= 0 && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		informazione := data{
+			UUID:     get("uuid"),
+			Account:  get("title"),
+			URL:      get("url"),
+			Username: get("username"),
+			Password: get("password"),
+			Notes:    get("notes"),
+			Group:    "CSV",
+		}
+		if informazione.Account == "" {
+			informazione.Account = informazione.URL
+		}
+		if group := get("group"); group != "" {
+			informazione.Group += "/" + group
+		}
+		// Firefox: milliseconds since the epoch
+		if ms, err := strconv.ParseInt(get("modified"), 10, 64); err == nil {
+			informazione.Modified = time.Unix(ms/1000, 0)
+		}
+		informazioni = append(informazioni, informazione)
+	}
+	return informazioni, nil
+}
+
+/*
+	pass: a password-store like tree of plaintext files (already decrypted).
+
+	The path of each file is group and title, the first line is the password and the
+	following "key: value" lines may carry login/username/user and url.
+*/
+
+type passImporter struct{}
+
+// passEntryName drops the extension of the store files, only that: github.com stays github.com
+func passEntryName(rel string) string {
+	for _, ext := range []string{".gpg", ".txt"} {
+		if strings.HasSuffix(rel, ext) && len(rel) > len(ext) {
+			return strings.TrimSuffix(rel, ext)
+		}
+	}
+	return rel
+}
+
+func (passImporter) name() string { return "pass" }
+
+func (passImporter) detect(path string, head []byte) bool {
+	return head == nil
+}
+
+func (passImporter) load(root string) ([]data, error) {
+	var informazioni []data
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(info.Name(), ".") && path != root {
+			// .git, .gpg-id and friends
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		rel = filepath.ToSlash(passEntryName(rel))
+		informazione := data{
+			UUID:     rel,
+			Account:  filepath.Base(rel),
+			Group:    strings.TrimSuffix("pass/"+filepath.Dir(rel), "/."),
+			Modified: info.ModTime(),
+		}
+		lines := strings.Split(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n")
+		informazione.Password = lines[0]
+		var notes []string
+		for _, line := range lines[1:] {
+			spl := strings.SplitN(line, ":", 2)
+			key := strings.ToLower(strings.TrimSpace(spl[0]))
+			switch {
+			case len(spl) == 2 && (key == "login" || key == "username" || key == "user"):
+				informazione.Username = strings.TrimSpace(spl[1])
+			case len(spl) == 2 && key == "url":
+				informazione.URL = strings.TrimSpace(spl[1])
+			case strings.TrimSpace(line) != "":
+				notes = append(notes, line)
+			}
+		}
+		informazione.Notes = strings.Join(notes, "\n")
+		informazioni = append(informazioni, informazione)
+		return nil
+	})
+	return informazioni, err
+}
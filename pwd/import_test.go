@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestPassImporter(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"web/github.gpg":       "secret1\nlogin: alice\nurl: https://github.com\n",
+		"web/github.com.gpg":   "secret2\n",
+		"web/gitlab.com.txt":   "secret3\nuser: bob\nsome note\n",
+		"mail/example.org":     "secret4",
+		".git/config":          "not a password",
+		"mail/.gpg-id":         "ABCDEF",
+		"archive/old.site.gpg": "secret5\r\nusername: carol\r\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := passImporter{}.load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]data)
+	var uuids []string
+	for _, e := range entries {
+		got[e.Password] = e
+		uuids = append(uuids, e.UUID)
+	}
+	sort.Strings(uuids)
+	want := []string{"archive/old.site", "mail/example.org", "web/github", "web/github.com", "web/gitlab.com"}
+	if len(uuids) != len(want) {
+		t.Fatalf("got %v, want %v", uuids, want)
+	}
+	for i := range want {
+		if uuids[i] != want[i] {
+			t.Fatalf("got %v, want %v", uuids, want)
+		}
+	}
+
+	tests := []struct {
+		password, account, group, username, url string
+	}{
+		{"secret1", "github", "pass/web", "alice", "https://github.com"},
+		{"secret2", "github.com", "pass/web", "", ""},
+		{"secret3", "gitlab.com", "pass/web", "bob", ""},
+		{"secret4", "example.org", "pass/mail", "", ""},
+		{"secret5", "old.site", "pass/archive", "carol", ""},
+	}
+	for _, test := range tests {
+		e, ok := got[test.password]
+		if !ok {
+			t.Errorf("%s: missing", test.password)
+			continue
+		}
+		if e.Account != test.account || e.Group != test.group || e.Username != test.username || e.URL != test.url {
+			t.Errorf("%s: got %q %q %q %q", test.password, e.Account, e.Group, e.Username, e.URL)
+		}
+	}
+	if got["secret3"].Notes != "some note" {
+		t.Errorf("notes %q", got["secret3"].Notes)
+	}
+}
@@ -0,0 +1,955 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/salsa20/salsa"
+	"golang.org/x/term"
+)
+
+/*
+	Native KDBX 3.1 and 4.x reader (and writer, for the remediation markers).
+
+	Everything happens in memory: the database is decrypted, decompressed and its
+	protected values are unveiled, then the inner XML is handed over to the same
+	xml.Unmarshal + parseGroup walk used for plaintext exports.
+*/
+
+const (
+	kdbxSignature1 uint32 = 0x9AA2D903
+	kdbxSignature2 uint32 = 0xB54BFB67
+)
+
+// outer header field ids
+const (
+	hdrEnd                 = 0
+	hdrComment             = 1
+	hdrCipherID            = 2
+	hdrCompressionFlags    = 3
+	hdrMasterSeed          = 4
+	hdrTransformSeed       = 5
+	hdrTransformRounds     = 6
+	hdrEncryptionIV        = 7
+	hdrProtectedStreamKey  = 8
+	hdrStreamStartBytes    = 9
+	hdrInnerRandomStreamID = 10
+	hdrKdfParameters       = 11
+	hdrPublicCustomData    = 12
+)
+
+// inner header field ids (KDBX 4)
+const (
+	innerEnd                = 0
+	innerRandomStreamID     = 1
+	innerRandomStreamKey    = 2
+	innerBinary             = 3
+	innerRandomStreamSalsa  = 2
+	innerRandomStreamChaCha = 3
+)
+
+var (
+	cipherAES256   = mustUUID("31c1f2e6bf714350be5805216afc5aff")
+	cipherChaCha20 = mustUUID("d6038a2b8b6f4cb5a524339a31dbb59a")
+	cipherTwofish  = mustUUID("ad68f29f576f4bb9a36ad47af965346c")
+	kdfAES         = mustUUID("c9d9f39a628a4460bf740d08c18a4fea")
+	kdfAES4        = mustUUID("7c02bb8279a74ac0927d114a00648238")
+	kdfArgon2d     = mustUUID("ef636ddf8c29444b91f7a9a403e30a0c")
+	kdfArgon2id    = mustUUID("9e298b1956db4773b23dfc3ec6f0a1e6")
+
+	salsa20Nonce = []byte{0xE8, 0x30, 0x09, 0x4B, 0x97, 0x20, 0x5D, 0x2A}
+
+	errNotKDBX      = errors.New("not a KDBX database")
+	errWrongKey     = errors.New("wrong master key or corrupted database")
+	errCorruptBlock = errors.New("corrupted database: block integrity check failed")
+)
+
+func mustUUID(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+type headerField struct {
+	id   byte
+	data []byte
+}
+
+type kdbxHeader struct {
+	major  uint16
+	minor  uint16
+	fields []headerField
+	raw    []byte // signatures, version and fields, as read from disk
+}
+
+func (h *kdbxHeader) field(id byte) []byte {
+	for _, f := range h.fields {
+		if f.id == id {
+			return f.data
+		}
+	}
+	return nil
+}
+
+func (h *kdbxHeader) uint32Field(id byte) uint32 {
+	if b := h.field(id); len(b) >= 4 {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+// isKDBX peeks at the signatures
+func isKDBX(content []byte) bool {
+	return len(content) >= 8 &&
+		binary.LittleEndian.Uint32(content[0:4]) == kdbxSignature1 &&
+		binary.LittleEndian.Uint32(content[4:8]) == kdbxSignature2
+}
+
+func readHeader(content []byte) (*kdbxHeader, error) {
+	if !isKDBX(content) || len(content) < 12 {
+		return nil, errNotKDBX
+	}
+	h := &kdbxHeader{
+		minor: binary.LittleEndian.Uint16(content[8:10]),
+		major: binary.LittleEndian.Uint16(content[10:12]),
+	}
+	if h.major > 4 {
+		return nil, fmt.Errorf("unsupported KDBX version %d.%d", h.major, h.minor)
+	}
+
+	// KDBX 4 uses 32 bit field sizes, older versions 16 bit ones
+	pos := 12
+	for {
+		var size int
+		if pos >= len(content) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		id := content[pos]
+		pos++
+		if h.major >= 4 {
+			if pos+4 > len(content) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			size = int(binary.LittleEndian.Uint32(content[pos:]))
+			pos += 4
+		} else {
+			if pos+2 > len(content) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			size = int(binary.LittleEndian.Uint16(content[pos:]))
+			pos += 2
+		}
+		if size < 0 || pos+size > len(content) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		h.fields = append(h.fields, headerField{id, content[pos : pos+size]})
+		pos += size
+		if id == hdrEnd {
+			break
+		}
+	}
+	h.raw = content[:pos]
+	return h, nil
+}
+
+// kdbxDatabase is an opened database, with what is needed to save it again
+type kdbxDatabase struct {
+	header      *kdbxHeader
+	transformed []byte        // output of the key derivation function
+	inner       []headerField // KDBX 4 inner header: protected stream and attachments
+	content     []byte        // inner XML with plaintext values
+}
+
+// openKDBX decrypts the database, its inner XML has plaintext values
+func openKDBX(content []byte, password []byte, keyFile string) (*kdbxDatabase, error) {
+	h, err := readHeader(content)
+	if err != nil {
+		return nil, err
+	}
+	composite, err := compositeKey(password, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	transformed, err := h.transformKey(composite)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &kdbxDatabase{header: h, transformed: transformed}
+	var payload []byte
+	var stream cipher.Stream
+	if h.major >= 4 {
+		payload, db.inner, err = h.readPayload4(content[len(h.raw):], transformed)
+		if err == nil {
+			stream, err = newInnerStream(db.innerStreamID(), db.innerField(innerRandomStreamKey))
+		}
+	} else {
+		payload, err = h.readPayload3(content[len(h.raw):], transformed)
+		if err == nil {
+			stream, err = newInnerStream(h.uint32Field(hdrInnerRandomStreamID), h.field(hdrProtectedStreamKey))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	db.content, err = unprotectXML(payload, stream)
+	return db, err
+}
+
+func (db *kdbxDatabase) innerField(id byte) []byte {
+	for _, f := range db.inner {
+		if f.id == id {
+			return f.data
+		}
+	}
+	return nil
+}
+
+func (db *kdbxDatabase) innerStreamID() uint32 {
+	if b := db.innerField(innerRandomStreamID); len(b) >= 4 {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+// transformKey runs the key derivation function configured in the header
+func (h *kdbxHeader) transformKey(composite []byte) ([]byte, error) {
+	if h.major < 4 {
+		var rounds uint64
+		if b := h.field(hdrTransformRounds); len(b) >= 8 {
+			rounds = binary.LittleEndian.Uint64(b)
+		}
+		return aesKDF(composite, h.field(hdrTransformSeed), rounds)
+	}
+
+	params, err := readVariantDictionary(h.field(hdrKdfParameters))
+	if err != nil {
+		return nil, err
+	}
+	uuid, _ := params["$UUID"].([]byte)
+	switch {
+	case bytes.Equal(uuid, kdfAES), bytes.Equal(uuid, kdfAES4):
+		rounds, _ := params["R"].(uint64)
+		seed, _ := params["S"].([]byte)
+		return aesKDF(composite, seed, rounds)
+	case bytes.Equal(uuid, kdfArgon2d), bytes.Equal(uuid, kdfArgon2id):
+		p := argon2Params{mode: argon2d}
+		if bytes.Equal(uuid, kdfArgon2id) {
+			p.mode = argon2id
+		}
+		salt, _ := params["S"].([]byte)
+		iterations, _ := params["I"].(uint64)
+		memory, _ := params["M"].(uint64)
+		p.parallelism, _ = params["P"].(uint32)
+		p.version, _ = params["V"].(uint32)
+		p.secret, _ = params["K"].([]byte)
+		p.data, _ = params["A"].([]byte)
+		p.iterations = uint32(iterations)
+		p.memory = uint32(memory / 1024)
+		return argon2Key(composite, salt, p, 32), nil
+	}
+	return nil, fmt.Errorf("unsupported key derivation function %x", uuid)
+}
+
+func aesKDF(composite, seed []byte, rounds uint64) ([]byte, error) {
+	block, err := aes.NewCipher(seed)
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, len(composite))
+	copy(key, composite)
+	for i := uint64(0); i < rounds; i++ {
+		block.Encrypt(key[:16], key[:16])
+		block.Encrypt(key[16:], key[16:])
+	}
+	sum := sha256.Sum256(key)
+	return sum[:], nil
+}
+
+func (h *kdbxHeader) readPayload3(payload []byte, transformed []byte) ([]byte, error) {
+	key := sha256.Sum256(append(append([]byte{}, h.field(hdrMasterSeed)...), transformed...))
+	plain, err := decryptPayload(h.field(hdrCipherID), key[:], h.field(hdrEncryptionIV), payload)
+	if err != nil {
+		return nil, err
+	}
+	start := h.field(hdrStreamStartBytes)
+	if len(plain) < len(start) || !bytes.Equal(plain[:len(start)], start) {
+		return nil, errWrongKey
+	}
+
+	// hashed block stream: index, sha256, size, data
+	var blocks []byte
+	rest := plain[len(start):]
+	for {
+		if len(rest) < 40 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		hash := rest[4:36]
+		size := int(binary.LittleEndian.Uint32(rest[36:40]))
+		rest = rest[40:]
+		if size == 0 {
+			break
+		}
+		if size > len(rest) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		sum := sha256.Sum256(rest[:size])
+		if !bytes.Equal(sum[:], hash) {
+			return nil, errCorruptBlock
+		}
+		blocks = append(blocks, rest[:size]...)
+		rest = rest[size:]
+	}
+
+	if h.uint32Field(hdrCompressionFlags) == 1 {
+		if blocks, err = gunzip(blocks); err != nil {
+			return nil, err
+		}
+	}
+	return blocks, nil
+}
+
+func (h *kdbxHeader) readPayload4(payload []byte, transformed []byte) ([]byte, []headerField, error) {
+	masterSeed := h.field(hdrMasterSeed)
+	if len(payload) < 64 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	headerHash := sha256.Sum256(h.raw)
+	if !bytes.Equal(headerHash[:], payload[:32]) {
+		return nil, nil, errors.New("corrupted database: header checksum mismatch")
+	}
+	hmacKey := sha512.Sum512(append(append(append([]byte{}, masterSeed...), transformed...), 1))
+	if !hmac.Equal(blockHMAC(hmacKey[:], ^uint64(0), h.raw, false), payload[32:64]) {
+		return nil, nil, errWrongKey
+	}
+
+	// HMAC block stream: hmac, size, data
+	var blocks []byte
+	rest := payload[64:]
+	for i := uint64(0); ; i++ {
+		if len(rest) < 36 {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		mac := rest[:32]
+		size := int(binary.LittleEndian.Uint32(rest[32:36]))
+		if size < 0 || 36+size > len(rest) {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		data := rest[36 : 36+size]
+		if !hmac.Equal(blockHMAC(hmacKey[:], i, data, true), mac) {
+			return nil, nil, errCorruptBlock
+		}
+		rest = rest[36+size:]
+		if size == 0 {
+			break
+		}
+		blocks = append(blocks, data...)
+	}
+
+	key := sha256.Sum256(append(append([]byte{}, masterSeed...), transformed...))
+	plain, err := decryptPayload(h.field(hdrCipherID), key[:], h.field(hdrEncryptionIV), blocks)
+	if err != nil {
+		return nil, nil, err
+	}
+	if h.uint32Field(hdrCompressionFlags) == 1 {
+		if plain, err = gunzip(plain); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// inner header: protected stream and attachments
+	var inner []headerField
+	for {
+		if len(plain) < 5 {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		id := plain[0]
+		size := int(binary.LittleEndian.Uint32(plain[1:5]))
+		if size < 0 || 5+size > len(plain) {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		data := plain[5 : 5+size]
+		plain = plain[5+size:]
+		if id == innerEnd {
+			break
+		}
+		inner = append(inner, headerField{id, data})
+	}
+	return plain, inner, nil
+}
+
+// blockHMAC authenticates a KDBX 4 block, or the header when index is all ones
+func blockHMAC(hmacKey []byte, index uint64, data []byte, withSize bool) []byte {
+	var idx [8]byte
+	binary.LittleEndian.PutUint64(idx[:], index)
+	key := sha512.Sum512(append(idx[:], hmacKey...))
+	mac := hmac.New(sha256.New, key[:])
+	if withSize {
+		var size [4]byte
+		binary.LittleEndian.PutUint32(size[:], uint32(len(data)))
+		mac.Write(idx[:])
+		mac.Write(size[:])
+	}
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func decryptPayload(cipherID, key, iv, data []byte) ([]byte, error) {
+	switch {
+	case bytes.Equal(cipherID, cipherAES256):
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 || len(data)%aes.BlockSize != 0 || len(iv) != aes.BlockSize {
+			return nil, errWrongKey
+		}
+		plain := make([]byte, len(data))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+		// PKCS#7 padding
+		pad := int(plain[len(plain)-1])
+		if pad == 0 || pad > aes.BlockSize || pad > len(plain) {
+			return nil, errWrongKey
+		}
+		return plain[:len(plain)-pad], nil
+	case bytes.Equal(cipherID, cipherChaCha20):
+		stream, err := chacha20.NewUnauthenticatedCipher(key, iv)
+		if err != nil {
+			return nil, err
+		}
+		plain := make([]byte, len(data))
+		stream.XORKeyStream(plain, data)
+		return plain, nil
+	case bytes.Equal(cipherID, cipherTwofish):
+		return nil, errors.New("Twofish encrypted databases are not supported")
+	}
+	return nil, fmt.Errorf("unknown cipher %x", cipherID)
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// size of the fixed-length kinds of a variant dictionary
+var variantSize = map[byte]int{0x04: 4, 0x05: 8, 0x0C: 4, 0x0D: 8}
+
+// readVariantDictionary parses the KDBX 4 KDF parameters
+func readVariantDictionary(b []byte) (map[string]interface{}, error) {
+	dict := make(map[string]interface{})
+	if len(b) < 2 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if b[1] > 1 {
+		return nil, fmt.Errorf("unsupported variant dictionary version %d.%d", b[1], b[0])
+	}
+	b = b[2:]
+	for len(b) > 0 {
+		kind := b[0]
+		if kind == 0 {
+			break
+		}
+		if len(b) < 5 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		keyLen := int(binary.LittleEndian.Uint32(b[1:5]))
+		b = b[5:]
+		if keyLen < 0 || keyLen+4 > len(b) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		key := string(b[:keyLen])
+		valueLen := int(binary.LittleEndian.Uint32(b[keyLen:]))
+		b = b[keyLen+4:]
+		if valueLen < 0 || valueLen > len(b) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		value := b[:valueLen]
+		b = b[valueLen:]
+		if size := variantSize[kind]; size != 0 && len(value) != size {
+			return nil, fmt.Errorf("malformed variant dictionary value %q", key)
+		}
+		switch kind {
+		case 0x04:
+			dict[key] = binary.LittleEndian.Uint32(value)
+		case 0x05:
+			dict[key] = binary.LittleEndian.Uint64(value)
+		case 0x08:
+			dict[key] = len(value) > 0 && value[0] != 0
+		case 0x0C:
+			dict[key] = int32(binary.LittleEndian.Uint32(value))
+		case 0x0D:
+			dict[key] = int64(binary.LittleEndian.Uint64(value))
+		case 0x18:
+			dict[key] = string(value)
+		case 0x42:
+			dict[key] = append([]byte{}, value...)
+		}
+	}
+	return dict, nil
+}
+
+// compositeKey combines the master password and the key file like KeePass does
+func compositeKey(password []byte, keyFile string) ([]byte, error) {
+	composite := sha256.New()
+	if len(password) > 0 || keyFile == "" {
+		sum := sha256.Sum256(password)
+		composite.Write(sum[:])
+	}
+	if keyFile != "" {
+		key, err := readKeyFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		composite.Write(key)
+	}
+	return composite.Sum(nil), nil
+}
+
+type keyFileXML struct {
+	XMLName xml.Name `xml:"KeyFile"`
+	Version string   `xml:"Meta>Version"`
+	Data    string   `xml:"Key>Data"`
+}
+
+// readKeyFile supports XML key files (1.0 and 2.0), raw 32 bytes, 64 hex chars and any other file
+func readKeyFile(path string) ([]byte, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyXML keyFileXML
+	if xml.Unmarshal(content, &keyXML) == nil && keyXML.Data != "" {
+		if strings.HasPrefix(keyXML.Version, "2.") {
+			return hex.DecodeString(strings.Join(strings.Fields(keyXML.Data), ""))
+		}
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(keyXML.Data))
+	}
+	if len(content) == 32 {
+		return content, nil
+	}
+	if len(content) == 64 {
+		if key, err := hex.DecodeString(string(content)); err == nil {
+			return key, nil
+		}
+	}
+	sum := sha256.Sum256(content)
+	return sum[:], nil
+}
+
+// newInnerStream creates the cipher protecting the values marked as Protected in the XML
+func newInnerStream(id uint32, key []byte) (cipher.Stream, error) {
+	switch id {
+	case innerRandomStreamSalsa:
+		sum := sha256.Sum256(key)
+		return newSalsa20Stream(sum, salsa20Nonce), nil
+	case innerRandomStreamChaCha:
+		sum := sha512.Sum512(key)
+		return chacha20.NewUnauthenticatedCipher(sum[:32], sum[32:44])
+	case 0:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported inner random stream %d", id)
+}
+
+// salsa20Stream keeps the position in the key stream between protected values
+type salsa20Stream struct {
+	key     [32]byte
+	counter [16]byte
+	block   [64]byte
+	used    int
+}
+
+func newSalsa20Stream(key [32]byte, nonce []byte) *salsa20Stream {
+	s := &salsa20Stream{key: key, used: 64}
+	copy(s.counter[:8], nonce)
+	return s
+}
+
+func (s *salsa20Stream) XORKeyStream(dst, src []byte) {
+	var zero [64]byte
+	for i := range src {
+		if s.used == len(s.block) {
+			salsa.XORKeyStream(s.block[:], zero[:], &s.counter, &s.key)
+			binary.LittleEndian.PutUint64(s.counter[8:], binary.LittleEndian.Uint64(s.counter[8:])+1)
+			s.used = 0
+		}
+		dst[i] = src[i] ^ s.block[s.used]
+		s.used++
+	}
+}
+
+/*
+unprotectXML walks the inner XML in document order, the same order used to
+protect the values, replacing every <Value Protected="True">base64</Value> with
+<Value ProtectInMemory="True">plaintext</Value>, as in a plaintext export.
+*/
+func unprotectXML(content []byte, stream cipher.Stream) ([]byte, error) {
+	var out bytes.Buffer
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	encoder := xml.NewEncoder(&out)
+	protected := false
+	var value []byte
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			protected = false
+			for i, attr := range t.Attr {
+				if attr.Name.Local == "Protected" && strings.EqualFold(attr.Value, "true") {
+					protected = true
+					t.Attr[i] = xml.Attr{Name: xml.Name{Local: "ProtectInMemory"}, Value: "True"}
+				}
+			}
+			value = nil
+			token = t
+		case xml.CharData:
+			if protected {
+				value = append(value, t...)
+				continue
+			}
+			if err := writeText(encoder, &out, t); err != nil {
+				return nil, err
+			}
+			continue
+		case xml.EndElement:
+			if protected {
+				protected = false
+				plain, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(value)))
+				if err != nil {
+					return nil, err
+				}
+				if stream != nil {
+					stream.XORKeyStream(plain, plain)
+				}
+				if err := writeText(encoder, &out, plain); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if err := encoder.EncodeToken(xml.CopyToken(token)); err != nil {
+			return nil, err
+		}
+	}
+	if err := encoder.Flush(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+/*
+saveKDBX encrypts content again with the same key and settings: fresh master
+seed, IV and protected stream key, the key derivation is not repeated.
+*/
+func saveKDBX(db *kdbxDatabase, content []byte) ([]byte, error) {
+	h := &kdbxHeader{major: db.header.major, minor: db.header.minor}
+	for _, f := range db.header.fields {
+		data := f.data
+		switch f.id {
+		case hdrMasterSeed, hdrEncryptionIV, hdrStreamStartBytes, hdrProtectedStreamKey:
+			data = make([]byte, len(f.data))
+			if _, err := rand.Read(data); err != nil {
+				return nil, err
+			}
+		}
+		h.fields = append(h.fields, headerField{f.id, data})
+	}
+	h.raw = h.bytes()
+
+	var streamID uint32
+	var streamKey []byte
+	if h.major >= 4 {
+		streamID = db.innerStreamID()
+		streamKey = make([]byte, len(db.innerField(innerRandomStreamKey)))
+		if _, err := rand.Read(streamKey); err != nil {
+			return nil, err
+		}
+	} else {
+		streamID = h.uint32Field(hdrInnerRandomStreamID)
+		streamKey = h.field(hdrProtectedStreamKey)
+	}
+	stream, err := newInnerStream(streamID, streamKey)
+	if err != nil {
+		return nil, err
+	}
+	// KDBX 3.1 keeps the hash of the outer header in Meta
+	var headerHash []byte
+	if h.major < 4 {
+		sum := sha256.Sum256(h.raw)
+		headerHash = sum[:]
+	}
+	content, err = protectXML(content, stream, headerHash)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.major >= 4 {
+		var inner bytes.Buffer
+		for _, f := range db.inner {
+			if f.id == innerRandomStreamKey {
+				f.data = streamKey
+			}
+			writeInnerField(&inner, f.id, f.data)
+		}
+		writeInnerField(&inner, innerEnd, nil)
+		inner.Write(content)
+		return h.writePayload4(inner.Bytes(), db.transformed)
+	}
+	return h.writePayload3(content, db.transformed)
+}
+
+// bytes serializes signatures, version and fields
+func (h *kdbxHeader) bytes() []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, []uint32{kdbxSignature1, kdbxSignature2})
+	binary.Write(&b, binary.LittleEndian, []uint16{h.minor, h.major})
+	for _, f := range h.fields {
+		b.WriteByte(f.id)
+		if h.major >= 4 {
+			binary.Write(&b, binary.LittleEndian, uint32(len(f.data)))
+		} else {
+			binary.Write(&b, binary.LittleEndian, uint16(len(f.data)))
+		}
+		b.Write(f.data)
+	}
+	return b.Bytes()
+}
+
+func writeInnerField(b *bytes.Buffer, id byte, data []byte) {
+	b.WriteByte(id)
+	binary.Write(b, binary.LittleEndian, uint32(len(data)))
+	b.Write(data)
+}
+
+func (h *kdbxHeader) writePayload3(content []byte, transformed []byte) ([]byte, error) {
+	var err error
+	if h.uint32Field(hdrCompressionFlags) == 1 {
+		if content, err = gzipBytes(content); err != nil {
+			return nil, err
+		}
+	}
+	// stream start bytes, a single hashed block and the empty final one
+	var plain bytes.Buffer
+	plain.Write(h.field(hdrStreamStartBytes))
+	sum := sha256.Sum256(content)
+	binary.Write(&plain, binary.LittleEndian, uint32(0))
+	plain.Write(sum[:])
+	binary.Write(&plain, binary.LittleEndian, uint32(len(content)))
+	plain.Write(content)
+	binary.Write(&plain, binary.LittleEndian, uint32(1))
+	plain.Write(make([]byte, 32))
+	binary.Write(&plain, binary.LittleEndian, uint32(0))
+
+	key := sha256.Sum256(append(append([]byte{}, h.field(hdrMasterSeed)...), transformed...))
+	payload, err := encryptPayload(h.field(hdrCipherID), key[:], h.field(hdrEncryptionIV), plain.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return append(h.raw, payload...), nil
+}
+
+// KDBX 4 HMAC blocks, like KeePass
+const kdbxBlockSize = 1024 * 1024
+
+func (h *kdbxHeader) writePayload4(plain []byte, transformed []byte) ([]byte, error) {
+	var err error
+	if h.uint32Field(hdrCompressionFlags) == 1 {
+		if plain, err = gzipBytes(plain); err != nil {
+			return nil, err
+		}
+	}
+	masterSeed := h.field(hdrMasterSeed)
+	key := sha256.Sum256(append(append([]byte{}, masterSeed...), transformed...))
+	encrypted, err := encryptPayload(h.field(hdrCipherID), key[:], h.field(hdrEncryptionIV), plain)
+	if err != nil {
+		return nil, err
+	}
+
+	hmacKey := sha512.Sum512(append(append(append([]byte{}, masterSeed...), transformed...), 1))
+	out := bytes.NewBuffer(append([]byte{}, h.raw...))
+	headerHash := sha256.Sum256(h.raw)
+	out.Write(headerHash[:])
+	out.Write(blockHMAC(hmacKey[:], ^uint64(0), h.raw, false))
+	for i := uint64(0); ; i++ {
+		size := len(encrypted)
+		if size > kdbxBlockSize {
+			size = kdbxBlockSize
+		}
+		block := encrypted[:size]
+		encrypted = encrypted[size:]
+		out.Write(blockHMAC(hmacKey[:], i, block, true))
+		binary.Write(out, binary.LittleEndian, uint32(size))
+		out.Write(block)
+		if size == 0 {
+			break
+		}
+	}
+	return out.Bytes(), nil
+}
+
+func encryptPayload(cipherID, key, iv, data []byte) ([]byte, error) {
+	switch {
+	case bytes.Equal(cipherID, cipherAES256):
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(iv) != aes.BlockSize {
+			return nil, errors.New("invalid AES IV in the header")
+		}
+		// PKCS#7 padding
+		pad := aes.BlockSize - len(data)%aes.BlockSize
+		plain := append(append([]byte{}, data...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+		encrypted := make([]byte, len(plain))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, plain)
+		return encrypted, nil
+	case bytes.Equal(cipherID, cipherChaCha20):
+		stream, err := chacha20.NewUnauthenticatedCipher(key, iv)
+		if err != nil {
+			return nil, err
+		}
+		encrypted := make([]byte, len(data))
+		stream.XORKeyStream(encrypted, data)
+		return encrypted, nil
+	}
+	return nil, fmt.Errorf("unsupported cipher %x", cipherID)
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+/*
+protectXML is the opposite of unprotectXML: every <Value ProtectInMemory="True">
+becomes <Value Protected="True"> with the value encrypted by the inner stream.
+When headerHash is given it replaces the content of Meta/HeaderHash.
+*/
+func protectXML(content []byte, stream cipher.Stream, headerHash []byte) ([]byte, error) {
+	var out bytes.Buffer
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	encoder := xml.NewEncoder(&out)
+	protected := false
+	inHeaderHash := false
+	var value []byte
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			protected = false
+			inHeaderHash = t.Name.Local == "HeaderHash" && headerHash != nil
+			for i, attr := range t.Attr {
+				if attr.Name.Local == "ProtectInMemory" && strings.EqualFold(attr.Value, "true") {
+					protected = true
+					t.Attr[i] = xml.Attr{Name: xml.Name{Local: "Protected"}, Value: "True"}
+				}
+			}
+			value = nil
+			token = t
+		case xml.CharData:
+			if protected || inHeaderHash {
+				value = append(value, t...)
+				continue
+			}
+			if err := writeText(encoder, &out, t); err != nil {
+				return nil, err
+			}
+			continue
+		case xml.EndElement:
+			if inHeaderHash {
+				inHeaderHash = false
+				value = []byte(base64.StdEncoding.EncodeToString(headerHash))
+				if err := writeText(encoder, &out, value); err != nil {
+					return nil, err
+				}
+			}
+			if protected {
+				protected = false
+				if stream != nil {
+					stream.XORKeyStream(value, value)
+				}
+				if err := writeText(encoder, &out, []byte(base64.StdEncoding.EncodeToString(value))); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if err := encoder.EncodeToken(xml.CopyToken(token)); err != nil {
+			return nil, err
+		}
+	}
+	if err := encoder.Flush(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// only what must be escaped in a text node, \r would be normalized away by the parser
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+
+// writeText keeps tabs and newlines as they are, the encoder would escape them as &#x9; and &#xA;
+func writeText(encoder *xml.Encoder, out *bytes.Buffer, text []byte) error {
+	if err := encoder.Flush(); err != nil {
+		return err
+	}
+	textEscaper.WriteString(out, string(text))
+	return nil
+}
+
+// readMasterPassword asks for the password without echo, or reads a line when stdin is not a terminal
+func readMasterPassword(name string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprintf(os.Stderr, "Master password for %s: ", name)
+		password, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return password, err
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
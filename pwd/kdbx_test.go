@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// fixtures written by testdata/gen_kdbx.go
+var kdbxFixtures = []struct {
+	name, keyFile string
+}{
+	{"testdata/kdbx3-aes.kdbx", ""},
+	{"testdata/kdbx4-aes.kdbx", ""},
+	{"testdata/kdbx4-chacha20-keyfile.kdbx", "testdata/kdbx4.keyx"},
+}
+
+const kdbxPassword = "correct horse"
+
+// parseKeePassXML walks the inner XML like loadKeePass, with history and fields
+func parseKeePassXML(t *testing.T, content []byte) []data {
+	t.Helper()
+	var database result
+	if err := xml.Unmarshal(content, &database); err != nil {
+		t.Fatal(err)
+	}
+	opts := parseOptions{recycleBin: database.recycleBin(), history: true, fields: true}
+	var informazioni []data
+	for _, r := range database.Root {
+		for _, g := range r.Group {
+			informazioni = append(informazioni, g.parseGroup("", false, opts)...)
+		}
+	}
+	return informazioni
+}
+
+func openFixture(t *testing.T, name, keyFile string) *kdbxDatabase {
+	t.Helper()
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := openKDBX(content, []byte(kdbxPassword), keyFile)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return db
+}
+
+func checkFixtureRecords(t *testing.T, name string, informazioni []data) {
+	t.Helper()
+	want := []struct {
+		label, password, group string
+		inRecycleBin           bool
+	}{
+		{"Mail", "hunter2", "Root", false},
+		{"Mail / PIN", "4321", "Root", false},
+		{"Mail / history -1 (2022-05-01)", "password1", "Root", false},
+		{"Old & gone", "trashed", "Root/Recycle Bin", true},
+	}
+	if len(informazioni) != len(want) {
+		t.Fatalf("%s: got %d records, want %d: %+v", name, len(informazioni), len(want), informazioni)
+	}
+	for i, w := range want {
+		v := informazioni[i]
+		if v.label() != w.label || v.Password != w.password || v.Group != w.group || v.InRecycleBin != w.inRecycleBin {
+			t.Errorf("%s: record %d: %+v, want %+v", name, i, v, w)
+		}
+	}
+	mail := informazioni[0]
+	modified := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	if mail.Username != "alice" || len(mail.Tags) != 1 || mail.Tags[0] != "personal" || !mail.Modified.Equal(modified) {
+		t.Errorf("%s: mail: %+v", name, mail)
+	}
+}
+
+func TestOpenKDBX(t *testing.T) {
+	for _, f := range kdbxFixtures {
+		db := openFixture(t, f.name, f.keyFile)
+		checkFixtureRecords(t, f.name, parseKeePassXML(t, db.content))
+	}
+}
+
+func TestOpenKDBXWrongKey(t *testing.T) {
+	for _, f := range kdbxFixtures {
+		content, err := ioutil.ReadFile(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := openKDBX(content, []byte("wrong"), f.keyFile); err != errWrongKey {
+			t.Errorf("%s: wrong password: got %v, want %v", f.name, err, errWrongKey)
+		}
+		if f.keyFile == "" {
+			continue
+		}
+		if _, err := openKDBX(content, []byte(kdbxPassword), ""); err != errWrongKey {
+			t.Errorf("%s: no key file: got %v, want %v", f.name, err, errWrongKey)
+		}
+	}
+}
+
+func TestSaveKDBX(t *testing.T) {
+	for _, f := range kdbxFixtures {
+		db := openFixture(t, f.name, f.keyFile)
+		saved, err := saveKDBX(db, db.content)
+		if err != nil {
+			t.Fatalf("%s: %v", f.name, err)
+		}
+		again, err := openKDBX(saved, []byte(kdbxPassword), f.keyFile)
+		if err != nil {
+			t.Fatalf("%s: reopen: %v", f.name, err)
+		}
+		if bytes.Equal(again.header.field(hdrMasterSeed), db.header.field(hdrMasterSeed)) {
+			t.Errorf("%s: master seed not renewed", f.name)
+		}
+		checkFixtureRecords(t, f.name, parseKeePassXML(t, again.content))
+		if _, err := openKDBX(saved, []byte("wrong"), f.keyFile); err != errWrongKey {
+			t.Errorf("%s: saved with the wrong password: got %v", f.name, err)
+		}
+	}
+}
+
+func TestReadVariantDictionary(t *testing.T) {
+	entry := func(kind byte, key string, value []byte) []byte {
+		b := []byte{kind, byte(len(key)), 0, 0, 0}
+		b = append(b, key...)
+		b = append(b, byte(len(value)), 0, 0, 0)
+		return append(b, value...)
+	}
+	dict := append([]byte{0x00, 0x01}, entry(0x05, "I", []byte{2, 0, 0, 0, 0, 0, 0, 0})...)
+	dict = append(dict, entry(0x04, "P", []byte{4, 0, 0, 0})...)
+	dict = append(dict, 0)
+	params, err := readVariantDictionary(dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params["I"] != uint64(2) || params["P"] != uint32(4) {
+		t.Errorf("got %v", params)
+	}
+
+	for _, kind := range []byte{0x04, 0x05, 0x0C, 0x0D} {
+		dict := append([]byte{0x00, 0x01}, entry(kind, "M", []byte{1, 2})...)
+		if _, err := readVariantDictionary(append(dict, 0)); err == nil {
+			t.Errorf("kind %#x with 2 bytes accepted", kind)
+		}
+	}
+}
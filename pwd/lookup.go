@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+lookupAll returns the breach count of every hash, fetching each 5 chars prefix
+only once. The first error cancels the lookups still running (when the checker
+supports it) and the ones not started yet.
+*/
+func lookupAll(checker BreachChecker, hashes []string, workers int) ([]int, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	var prefixes []string
+	seen := make(map[string]bool)
+	for _, hash := range hashes {
+		if len(hash) <= 5 {
+			return nil, fmt.Errorf("invalid hash %q", hash)
+		}
+		short := strings.ToUpper(hash[:5])
+		if !seen[short] {
+			seen[short] = true
+			prefixes = append(prefixes, short)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		ranges   = make(map[string]map[string]int)
+		jobs     = make(chan string)
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for short := range jobs {
+				suffixes, err := rangeContext(ctx, checker, short)
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				ranges[short] = suffixes
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, short := range prefixes {
+		select {
+		case jobs <- short:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	counts := make([]int, len(hashes))
+	for i, hash := range hashes {
+		hash = strings.ToUpper(hash)
+		counts[i] = ranges[hash[:5]][hash[5:]]
+	}
+	return counts, nil
+}
+
+// tokenBucket allows rate requests per second, with bursts up to burst requests
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// wait blocks until a token is available, or the context is done
+func (b *tokenBucket) wait(ctx context.Context) error {
+	if b == nil || b.rate <= 0 {
+		return nil
+	}
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+		missing := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+		timer := time.NewTimer(missing)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// cachedChecker keeps the range responses on disk, one file per prefix, for ttl
+type cachedChecker struct {
+	source BreachChecker
+	dir    string
+	ttl    time.Duration
+}
+
+func newCachedChecker(source BreachChecker, dir string, ttl time.Duration) (*cachedChecker, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &cachedChecker{source: source, dir: dir, ttl: ttl}, nil
+}
+
+func (c *cachedChecker) Range(prefix string) (map[string]int, error) {
+	return c.RangeContext(context.Background(), prefix)
+}
+
+func (c *cachedChecker) RangeContext(ctx context.Context, prefix string) (map[string]int, error) {
+	prefix = strings.ToUpper(prefix)
+	path := filepath.Join(c.dir, prefix+".txt")
+	if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) < c.ttl {
+		if cached, err := os.Open(path); err == nil {
+			defer cached.Close()
+			return parseRange(cached, prefix)
+		}
+	}
+
+	suffixes, err := rangeContext(ctx, c.source, prefix)
+	if err != nil {
+		return nil, err
+	}
+	lines := make([]string, 0, len(suffixes))
+	for suffix, num := range suffixes {
+		lines = append(lines, fmt.Sprintf("%s:%d\r\n", suffix, num))
+	}
+	sort.Strings(lines)
+	// write and rename, a concurrent reader never sees half a file
+	tmp, err := ioutil.TempFile(c.dir, prefix+".*.tmp")
+	if err != nil {
+		return suffixes, nil
+	}
+	_, err = tmp.WriteString(strings.Join(lines, ""))
+	tmp.Close()
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return suffixes, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLookupAll(t *testing.T) {
+	checker := newStaticChecker(hashSHA1, map[string]int{"password": 42, "hunter2": 7})
+	hashes := []string{sha1Hex("password"), sha1Hex("unknown"), strings.ToLower(sha1Hex("hunter2"))}
+	counts, err := lookupAll(checker, hashes, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[0] != 42 || counts[1] != 0 || counts[2] != 7 {
+		t.Errorf("got %v, want [42 0 7]", counts)
+	}
+}
+
+func TestLookupAllFailsFast(t *testing.T) {
+	broken := sha1Hex("broken")[:5]
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, broken) {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		// every other prefix is retried, for an hour
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	api := newRangeAPI(server.URL)
+	api.backoff = time.Hour
+
+	hashes := []string{sha1Hex("a"), sha1Hex("b"), sha1Hex("broken"), sha1Hex("c"), sha1Hex("d"), sha1Hex("e")}
+	done := make(chan error, 1)
+	go func() {
+		_, err := lookupAll(api, hashes, 3)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "400") {
+			t.Errorf("got %v, want the 400 of %s", err, broken)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("lookupAll still waiting for the retries after the first error")
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+	Remediation markers: a copy of the KeePass database where every breached
+	entry has the "pwned" tag, a "Pwned" field with the breach count and the check
+	date, and expires now, so that KeePass highlights it.
+*/
+
+const (
+	markTag   = "pwned"
+	markField = "Pwned"
+)
+
+// xmlNode keeps any element, so that the rest of the database is written back untouched
+type xmlNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Content string     `xml:",chardata"`
+	Nodes   []xmlNode  `xml:",any"`
+}
+
+func (n *xmlNode) child(name string) *xmlNode {
+	for i := range n.Nodes {
+		if n.Nodes[i].XMLName.Local == name {
+			return &n.Nodes[i]
+		}
+	}
+	return nil
+}
+
+// insert adds the element before the first child named before, or at the end
+func (n *xmlNode) insert(node xmlNode, before string) *xmlNode {
+	for i := range n.Nodes {
+		if n.Nodes[i].XMLName.Local == before {
+			n.Nodes = append(n.Nodes[:i], append([]xmlNode{node}, n.Nodes[i:]...)...)
+			return &n.Nodes[i]
+		}
+	}
+	n.Nodes = append(n.Nodes, node)
+	return &n.Nodes[len(n.Nodes)-1]
+}
+
+// trim drops the indentation, the encoder indents again
+func (n *xmlNode) trim() {
+	if len(n.Nodes) > 0 {
+		n.Content = strings.TrimSpace(n.Content)
+	}
+	for i := range n.Nodes {
+		n.Nodes[i].trim()
+	}
+}
+
+func newXMLNode(name string, content string) xmlNode {
+	return xmlNode{XMLName: xml.Name{Local: name}, Content: content}
+}
+
+// pwnedMarks maps the UUID of the breached entries to their breach count, old revisions don't count
+func pwnedMarks(findings []finding) map[string]int {
+	marks := make(map[string]int)
+	for _, f := range findings {
+		if f.Pwned > 0 && f.Revision == 0 && f.Pwned > marks[f.UUID] {
+			marks[f.UUID] = f.Pwned
+		}
+	}
+	return marks
+}
+
+// markEntries returns the KeePass XML with the markers added to the entries in marks,
+// encoded is for KDBX 4, where times are base64 seconds instead of ISO 8601 dates
+func markEntries(content []byte, marks map[string]int, now time.Time, encoded bool) ([]byte, int, error) {
+	var doc xmlNode
+	if err := xml.Unmarshal(content, &doc); err != nil {
+		return nil, 0, err
+	}
+	doc.trim()
+	marked := 0
+	if root := doc.child("Root"); root != nil {
+		for i := range root.Nodes {
+			if root.Nodes[i].XMLName.Local == "Group" {
+				marked += markGroup(&root.Nodes[i], marks, now, encoded)
+			}
+		}
+	}
+
+	out, err := xml.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		return nil, 0, err
+	}
+	return append([]byte(xml.Header), out...), marked, nil
+}
+
+// markGroup walks the group and its subgroups, the history entries are left alone
+func markGroup(gruppo *xmlNode, marks map[string]int, now time.Time, encoded bool) int {
+	marked := 0
+	for i := range gruppo.Nodes {
+		node := &gruppo.Nodes[i]
+		switch node.XMLName.Local {
+		case "Group":
+			marked += markGroup(node, marks, now, encoded)
+		case "Entry":
+			uuid := node.child("UUID")
+			if uuid == nil {
+				continue
+			}
+			if num, ok := marks[uuid.Content]; ok {
+				markEntry(node, num, now, encoded)
+				marked++
+			}
+		}
+	}
+	return marked
+}
+
+func markEntry(e *xmlNode, num int, now time.Time, encoded bool) {
+	times := e.child("Times")
+	if times == nil {
+		times = e.insert(newXMLNode("Times", ""), "String")
+	}
+	expires := times.child("Expires")
+	if expires == nil {
+		expires = times.insert(newXMLNode("Expires", ""), "")
+	}
+	expires.Content = "True"
+	expiry := times.child("ExpiryTime")
+	if expiry == nil {
+		expiry = times.insert(newXMLNode("ExpiryTime", ""), "Expires")
+	}
+	expiry.Content = formatKeePassTime(now, encoded)
+
+	tags := e.child("Tags")
+	if tags == nil {
+		tags = e.insert(newXMLNode("Tags", ""), "Times")
+	}
+	found := false
+	for _, tag := range splitTags(tags.Content) {
+		found = found || tag == markTag
+	}
+	if !found {
+		if tags.Content != "" {
+			tags.Content += ";"
+		}
+		tags.Content += markTag
+	}
+
+	note := fmt.Sprintf("found in %d breaches, checked %s", num, now.Format("2006-01-02"))
+	var field *xmlNode
+	for i := range e.Nodes {
+		if e.Nodes[i].XMLName.Local == "String" {
+			if key := e.Nodes[i].child("Key"); key != nil && key.Content == markField {
+				field = &e.Nodes[i]
+			}
+		}
+	}
+	if field == nil {
+		field = e.insert(xmlNode{XMLName: xml.Name{Local: "String"}, Nodes: []xmlNode{
+			newXMLNode("Key", markField),
+			newXMLNode("Value", ""),
+		}}, "AutoType")
+	}
+	if value := field.child("Value"); value != nil {
+		value.Content = note
+	}
+}
+
+// formatKeePassTime is the opposite of parseKeePassTime
+func formatKeePassTime(t time.Time, encoded bool) string {
+	if !encoded {
+		return t.UTC().Format("2006-01-02T15:04:05Z")
+	}
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(t.Unix()+62135596800))
+	return base64.StdEncoding.EncodeToString(b[:])
+}
+
+// checkMarkOutput refuses to replace an existing file, the database itself included, without -overwrite
+func checkMarkOutput(output string, overwrite bool) error {
+	if overwrite {
+		return nil
+	}
+	if _, err := os.Stat(output); err == nil {
+		return fmt.Errorf("%s already exists, use -overwrite to replace it", output)
+	}
+	return nil
+}
+
+// writeMarked saves the marked copy, KDBX databases stay encrypted with the same key
+func writeMarked(file *keepassFile, output string, marks map[string]int, now time.Time) (int, error) {
+	encoded := file.kdbx != nil && file.kdbx.header.major >= 4
+	content, marked, err := markEntries(file.content, marks, now, encoded)
+	if err != nil {
+		return 0, err
+	}
+	if file.kdbx != nil {
+		if content, err = saveKDBX(file.kdbx, content); err != nil {
+			return 0, err
+		}
+	}
+
+	// a temporary file and a rename, a failure never leaves a truncated database behind
+	tmp, err := ioutil.TempFile(filepath.Dir(output), ".pwd-mark-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	return marked, os.Rename(tmp.Name(), output)
+}
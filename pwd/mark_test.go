@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMarkedExport(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "export.xml")
+	if err := ioutil.WriteFile(source, []byte(keepassExport), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, file, err := loadKeePass(source, "", parseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	output := filepath.Join(dir, "marked.xml")
+	marked, err := writeMarked(file, output, map[string]int{"c2VydmVyc2VydmVyc2VydmVy": 42}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if marked != 1 {
+		t.Errorf("marked %d entries, want 1", marked)
+	}
+
+	content, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<Tags>prod; ssh,db;pwned</Tags>",
+		"<Expires>True</Expires>",
+		"<ExpiryTime>2024-06-01T12:00:00Z</ExpiryTime>",
+		"<Key>Pwned</Key>",
+		"<Value>found in 42 breaches, checked 2024-06-01</Value>",
+	} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("marked copy misses %s:\n%s", want, content)
+		}
+	}
+	if n := strings.Count(string(content), "<Key>Pwned</Key>"); n != 1 {
+		t.Errorf("%d Pwned fields, the history must be left alone", n)
+	}
+
+	informazioni, _, err := loadKeePass(output, "", parseOptions{history: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, deleted := informazioni[0], informazioni[len(informazioni)-1]
+	if !server.Expired || len(server.Tags) != 4 || server.Tags[3] != markTag || server.Password != "current" {
+		t.Errorf("server: %+v", server)
+	}
+	if deleted.Account != "deleted" || len(deleted.Tags) != 0 || deleted.Expired {
+		t.Errorf("deleted: %+v", deleted)
+	}
+
+	// marking the marked copy again adds nothing
+	_, file, err = loadKeePass(output, "", parseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	again := filepath.Join(dir, "again.xml")
+	if _, err := writeMarked(file, again, map[string]int{"c2VydmVyc2VydmVyc2VydmVy": 43}, now); err != nil {
+		t.Fatal(err)
+	}
+	content, err = ioutil.ReadFile(again)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(content), "pwned") != 1 || strings.Count(string(content), "<Key>Pwned</Key>") != 1 ||
+		!strings.Contains(string(content), "found in 43 breaches") {
+		t.Errorf("marked twice:\n%s", content)
+	}
+}
+
+func TestWriteMarkedKDBX(t *testing.T) {
+	db := openFixture(t, "testdata/kdbx4-aes.kdbx", "")
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	output := filepath.Join(t.TempDir(), "marked.kdbx")
+	file := &keepassFile{content: db.content, kdbx: db}
+	if _, err := writeMarked(file, output, map[string]int{"bWFpbG1haWxtYWlsbWFpbA==": 7}, now); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	marked, err := openKDBX(content, []byte(kdbxPassword), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// KDBX 4 times are base64 seconds
+	if !bytes.Contains(marked.content, []byte("<ExpiryTime>"+formatKeePassTime(now, true)+"</ExpiryTime>")) {
+		t.Errorf("no encoded expiry time:\n%s", marked.content)
+	}
+	mail := parseKeePassXML(t, marked.content)[0]
+	if !mail.Expired || len(mail.Tags) != 2 || mail.Tags[1] != markTag || mail.Password != "hunter2" {
+		t.Errorf("mail: %+v", mail)
+	}
+}
+
+func TestCheckMarkOutput(t *testing.T) {
+	dir := t.TempDir()
+	database := filepath.Join(dir, "Database.kdbx")
+	if err := ioutil.WriteFile(database, []byte("keep me"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkMarkOutput(database, false); err == nil {
+		t.Error("existing file replaced without -overwrite")
+	}
+	if err := checkMarkOutput(database, true); err != nil {
+		t.Errorf("-overwrite: %v", err)
+	}
+	if err := checkMarkOutput(filepath.Join(dir, "marked.kdbx"), false); err != nil {
+		t.Errorf("new file: %v", err)
+	}
+}
+
+// the inner XML keeps the KeePass indentation byte for byte
+func TestProtectXMLRoundTrip(t *testing.T) {
+	plain := []byte("<?xml version=\"1.0\" encoding=\"utf-8\" standalone=\"yes\"?>\n<KeePassFile>\n" +
+		"\t<Meta>\n\t\t<HeaderHash>old</HeaderHash>\n\t</Meta>\n" +
+		"\t<Root>\n\t\t<Entry>\n\t\t\t<String>\n\t\t\t\t<Key>Password</Key>\n" +
+		"\t\t\t\t<Value ProtectInMemory=\"True\">\tsecret &amp; more</Value>\n" +
+		"\t\t\t</String>\n\t\t</Entry>\n\t</Root>\n</KeePassFile>")
+
+	key := bytes.Repeat([]byte{1}, 64)
+	stream, _ := newInnerStream(innerRandomStreamChaCha, key)
+	protected, err := protectXML(plain, stream, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(protected, []byte("&#x9;")) || bytes.Contains(protected, []byte("&#xA;")) {
+		t.Errorf("escaped indentation:\n%s", protected)
+	}
+	if bytes.Contains(protected, []byte("secret")) || !bytes.Contains(protected, []byte(`<Value Protected="True">`)) {
+		t.Errorf("value not protected:\n%s", protected)
+	}
+	stream, _ = newInnerStream(innerRandomStreamChaCha, key)
+	unprotected, err := unprotectXML(protected, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unprotected, plain) {
+		t.Errorf("round trip changed the XML:\n%s\nwant\n%s", unprotected, plain)
+	}
+
+	// KDBX 3.1 writes the hash of the header in Meta
+	protected, err = protectXML(plain, nil, []byte{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(protected, []byte("\n\t\t<HeaderHash>AQID</HeaderHash>\n\t</Meta>")) {
+		t.Errorf("header hash:\n%s", protected)
+	}
+}
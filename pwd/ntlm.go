@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4"
+)
+
+/*
+	NTLM mode, for Active Directory audits.
+
+	Pwned Passwords is also published as NT hashes: the range API answers with
+	?mode=ntlm and the same SUFFIX:COUNT protocol, and the downloadable dataset has
+	the same HASH:COUNT layout, so only the hash function changes.
+*/
+
+const (
+	hashSHA1 = "sha1"
+	hashNTLM = "ntlm"
+)
+
+// ntHash is MD4 of the UTF-16LE password, as stored by Windows
+func ntHash(password string) string {
+	h := md4.New()
+	for _, c := range utf16.Encode([]rune(password)) {
+		h.Write([]byte{byte(c), byte(c >> 8)})
+	}
+	return strings.ToUpper(hex.EncodeToString(h.Sum(nil)))
+}
+
+// hashPassword hashes with the function of the mode
+func hashPassword(mode string, password string) string {
+	if mode == hashNTLM {
+		return ntHash(password)
+	}
+	return sha1Hex(password)
+}
+
+// hashOf returns the hash to look up for the record, the dumps have no password but the hash itself
+func hashOf(mode string, v data) string {
+	if v.Hash != "" {
+		return strings.ToUpper(v.Hash)
+	}
+	return hashPassword(mode, v.Password)
+}
+
+// secretsdump: DOMAIN\user:rid:lmhash:nthash::: as written by impacket and pwdump
+var secretsdumpLine = regexp.MustCompile(`^([^:]+):(\d+):([0-9a-fA-F]{32}):([0-9a-fA-F]{32}):::`)
+
+// NT hash of the empty password
+const emptyNTHash = "31D6CFE0D16AE931B73C59D7E0C089C0"
+
+type secretsdumpImporter struct {
+	opts parseOptions
+}
+
+func (secretsdumpImporter) name() string { return "secretsdump" }
+
+func (secretsdumpImporter) detect(path string, head []byte) bool {
+	line, _, _ := bufio.NewReader(bytes.NewReader(head)).ReadLine()
+	return secretsdumpLine.Match(bytes.TrimSpace(line))
+}
+
+func (s secretsdumpImporter) load(path string) ([]data, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var informazioni []data
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		match := secretsdumpLine.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if match == nil {
+			continue
+		}
+		informazione := data{
+			UUID:  match[2],
+			Group: "AD",
+			Hash:  strings.ToUpper(match[4]),
+		}
+		account := match[1]
+		if spl := strings.SplitN(account, `\`, 2); len(spl) == 2 {
+			informazione.Group += "/" + spl[0]
+			account = spl[1]
+		}
+		// machine accounts have random passwords, history entries are user_history0, user_history1...
+		if strings.HasSuffix(account, "$") {
+			continue
+		}
+		if i := strings.Index(account, "_history"); i > 0 {
+			fmt.Sscanf(account[i:], "_history%d", &informazione.Revision)
+			informazione.Revision++
+			account = account[:i]
+		}
+		if informazione.Revision > 0 && !s.opts.history {
+			continue
+		}
+		informazione.Account = account
+		informazione.Username = account
+		informazione.Disabled = strings.Contains(scanner.Text(), "(status=Disabled)")
+		informazioni = append(informazioni, informazione)
+	}
+	return informazioni, scanner.Err()
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecretsdumpImporter(t *testing.T) {
+	lm := "aad3b435b51404eeaad3b435b51404ee"
+	dump := "CORP\\alice:1001:" + lm + ":" + ntHash("password") + ":::\n" +
+		"CORP\\bob:1002:" + lm + ":" + ntHash("hunter2") + "::: (status=Disabled)\n" +
+		"CORP\\bob_history0:1002:" + lm + ":" + ntHash("old") + ":::\n" +
+		"CORP\\WS01$:1003:" + lm + ":" + ntHash("random") + ":::\n"
+	path := filepath.Join(t.TempDir(), "ntds.txt")
+	if err := ioutil.WriteFile(path, []byte(dump), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := secretsdumpImporter{}.load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want alice and bob", len(entries))
+	}
+	alice, bob := entries[0], entries[1]
+	if alice.Account != "alice" || alice.Group != "AD/CORP" || alice.Hash != ntHash("password") || alice.Disabled {
+		t.Errorf("alice: %+v", alice)
+	}
+	if bob.Account != "bob" || !bob.Disabled || bob.Expired {
+		t.Errorf("bob: disabled %v expired %v", bob.Disabled, bob.Expired)
+	}
+
+	entries, err = secretsdumpImporter{opts: parseOptions{history: true}}.load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[2].Revision != 1 {
+		t.Errorf("history: %+v", entries)
+	}
+}
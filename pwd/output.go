@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// exit code when at least one entry is compromised, errors exit with 1 (log.Fatal)
+const exitPwned = 2
+
+// exitCode is exitPwned when at least one entry is breached, fixed entries have no count
+func exitCode(findings []finding) int {
+	for _, f := range findings {
+		if f.Pwned > 0 {
+			return exitPwned
+		}
+	}
+	return 0
+}
+
+type outputRecord struct {
+	UUID         string   `json:"uuid"`
+	Group        string   `json:"group"`
+	Title        string   `json:"title"`
+	Field        string   `json:"field,omitempty"`
+	Revision     int      `json:"revision,omitempty"`
+	Username     string   `json:"username"`
+	URL          string   `json:"url,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	Expired      bool     `json:"expired"`
+	Disabled     bool     `json:"disabled,omitempty"`
+	InRecycleBin bool     `json:"in_recycle_bin"`
+	Breaches     int      `json:"breaches"`
+	Issues       []string `json:"issues,omitempty"`
+	Change       string   `json:"change,omitempty"`
+}
+
+func newOutputRecord(f finding) outputRecord {
+	record := outputRecord{
+		UUID:         f.UUID,
+		Group:        f.Group,
+		Title:        f.Account,
+		Field:        f.Field,
+		Revision:     f.Revision,
+		Username:     f.Username,
+		URL:          f.URL,
+		Tags:         f.Tags,
+		Expired:      f.Expired,
+		Disabled:     f.Disabled,
+		InRecycleBin: f.InRecycleBin,
+		Breaches:     f.Pwned,
+		Change:       f.Change,
+	}
+	for _, i := range f.issues() {
+		record.Issues = append(record.Issues, i.id)
+	}
+	return record
+}
+
+// writeFindings prints the entries with at least one issue in the requested format,
+// and with -state the ones fixed since the last audit
+func writeFindings(w io.Writer, format string, findings []finding, report bool) error {
+	var records []outputRecord
+	var flagged []finding
+	changed := false
+	for _, f := range findings {
+		if len(f.issues()) > 0 {
+			flagged = append(flagged, f)
+		}
+		if len(f.issues()) > 0 || f.Change == changeFixed {
+			records = append(records, newOutputRecord(f))
+		}
+		changed = changed || f.Change != ""
+	}
+
+	switch format {
+	case "text":
+		if report {
+			printReport(w, findings)
+		} else {
+			for _, f := range flagged {
+				fmt.Fprintf(w, "CAMBIARE PWD PER [ %s ] : %d risultati\n", f.label(), f.Pwned)
+			}
+		}
+		if changed {
+			printChanges(w, findings)
+		}
+		return nil
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if records == nil {
+			records = []outputRecord{}
+		}
+		return encoder.Encode(records)
+	case "csv":
+		out := csv.NewWriter(w)
+		out.Write([]string{"uuid", "group", "title", "field", "revision", "username", "url", "tags", "expired", "in_recycle_bin", "breaches", "issues", "change", "disabled"})
+		for _, r := range records {
+			out.Write([]string{r.UUID, r.Group, r.Title, r.Field, strconv.Itoa(r.Revision), r.Username, r.URL, strings.Join(r.Tags, ";"),
+				strconv.FormatBool(r.Expired), strconv.FormatBool(r.InRecycleBin), strconv.Itoa(r.Breaches), strings.Join(r.Issues, " "), r.Change, strconv.FormatBool(r.Disabled)})
+		}
+		out.Flush()
+		return out.Error()
+	case "sarif":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(newSarifLog(flagged))
+	}
+	return fmt.Errorf("unknown format %q, use text, json, csv or sarif", format)
+}
+
+// a minimal SARIF 2.1.0 log, enough for the tools that collect static analysis results
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifLogicalLocation struct {
+	Name               string `json:"name"`
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+var sarifRules = []sarifRule{
+	{"pwned", sarifMessage{"Password found in a data breach"}},
+	{"empty", sarifMessage{"Empty password"}},
+	{"weak", sarifMessage{"Weak password"}},
+	{"reused", sarifMessage{"Password reused by other entries"}},
+	{"old", sarifMessage{"Password not changed for too long"}},
+}
+
+func newSarifLog(findings []finding) sarifLog {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "pwd",
+			InformationURI: "https://haveibeenpwned.com/Passwords",
+			Rules:          sarifRules,
+		}},
+		Results: []sarifResult{},
+	}
+	for _, f := range findings {
+		location := sarifLocation{LogicalLocations: []sarifLogicalLocation{{
+			Name:               f.label(),
+			FullyQualifiedName: f.Group + "/" + f.label() + " [" + f.UUID + "]",
+			Kind:               "entry",
+		}}}
+		for _, i := range f.issues() {
+			level := "warning"
+			if i.id == "pwned" || i.id == "empty" {
+				level = "error"
+			}
+			run.Results = append(run.Results, sarifResult{
+				RuleID:    i.id,
+				Level:     level,
+				Message:   sarifMessage{fmt.Sprintf("%s (%s): %s", f.label(), f.Username, i.text)},
+				Locations: []sarifLocation{location},
+			})
+		}
+	}
+	return sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}
+}
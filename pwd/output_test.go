@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+)
+
+func outputFindings() []finding {
+	return []finding{
+		{data: data{UUID: "u1", Group: "Root", Account: "mail", Username: "alice", Tags: []string{"a", "b"}}, Pwned: 3},
+		{data: data{UUID: "u2", Group: "Root", Account: "bank", Username: "alice"}, Weak: true, Entropy: 20},
+		{data: data{UUID: "u3", Group: "Root", Account: "fine", Username: "bob"}},
+		{data: data{UUID: "u4", Group: "Root/Old", Account: "forum"}, Change: changeFixed},
+	}
+}
+
+func TestWriteFindingsJSON(t *testing.T) {
+	var out bytes.Buffer
+	if err := writeFindings(&out, "json", outputFindings(), true); err != nil {
+		t.Fatal(err)
+	}
+	var records []map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &records); err != nil {
+		t.Fatalf("%v:\n%s", err, out.String())
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want mail, bank and the fixed forum:\n%s", len(records), out.String())
+	}
+	mail := records[0]
+	if mail["uuid"] != "u1" || mail["title"] != "mail" || mail["breaches"] != 3.0 || mail["in_recycle_bin"] != false {
+		t.Errorf("mail: %v", mail)
+	}
+	if issues, _ := mail["issues"].([]interface{}); len(issues) != 1 || issues[0] != "pwned" {
+		t.Errorf("mail issues: %v", mail["issues"])
+	}
+	if _, ok := mail["change"]; ok {
+		t.Errorf("change without -state: %v", mail)
+	}
+	if records[2]["change"] != changeFixed || records[2]["issues"] != nil {
+		t.Errorf("fixed: %v", records[2])
+	}
+
+	out.Reset()
+	if err := writeFindings(&out, "json", outputFindings()[2:3], false); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "[]\n" {
+		t.Errorf("nothing to report: got %q, want an empty list", out.String())
+	}
+}
+
+func TestWriteFindingsCSV(t *testing.T) {
+	var out bytes.Buffer
+	if err := writeFindings(&out, "csv", outputFindings(), true); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("got %d rows, want the header and 3 records: %v", len(rows), rows)
+	}
+	want := []string{"u1", "Root", "mail", "", "0", "alice", "", "a;b", "false", "false", "3", "pwned", "", "false"}
+	for i := range want {
+		if len(rows[0]) != len(want) || rows[1][i] != want[i] {
+			t.Fatalf("got %v\nwant %v\nheader %v", rows[1], want, rows[0])
+		}
+	}
+	if rows[2][11] != "weak" || rows[3][12] != changeFixed {
+		t.Errorf("bank %v, forum %v", rows[2], rows[3])
+	}
+}
+
+func TestWriteFindingsSARIF(t *testing.T) {
+	var out bytes.Buffer
+	if err := writeFindings(&out, "sarif", outputFindings(), true); err != nil {
+		t.Fatal(err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal(out.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 || len(log.Runs[0].Tool.Driver.Rules) != len(sarifRules) {
+		t.Fatalf("got %+v", log)
+	}
+	results := log.Runs[0].Results
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want mail and bank: %+v", len(results), results)
+	}
+	if results[0].RuleID != "pwned" || results[0].Level != "error" || results[1].RuleID != "weak" || results[1].Level != "warning" {
+		t.Errorf("got %+v", results)
+	}
+	location := results[0].Locations[0].LogicalLocations[0]
+	if location.FullyQualifiedName != "Root/mail [u1]" || location.Kind != "entry" {
+		t.Errorf("location %+v", location)
+	}
+}
+
+func TestWriteFindingsUnknownFormat(t *testing.T) {
+	if err := writeFindings(&bytes.Buffer{}, "xml", outputFindings(), false); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	findings := outputFindings()
+	if code := exitCode(findings); code != exitPwned {
+		t.Errorf("with a breached entry: got %d, want %d", code, exitPwned)
+	}
+	if code := exitCode(findings[1:]); code != 0 {
+		t.Errorf("weak and fixed entries only: got %d, want 0", code)
+	}
+}
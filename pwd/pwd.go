@@ -1,8 +1,10 @@
 package main
 
 import (
-	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/xml"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -12,15 +14,28 @@ import (
 	"time"
 )
 
+type value struct {
+	Text            string `xml:",chardata"`
+	ProtectInMemory string `xml:",attr"`
+}
 type singleEntry struct {
 	Key   string
-	Value string
+	Value value
+}
+type times struct {
+	LastModificationTime string
+	Expires              string
+	ExpiryTime           string
 }
 type entry struct {
 	UUID          string
+	Tags          string
+	Times         times
 	SingleEntries []singleEntry `xml:"String"`
+	History       []entry       `xml:"History>Entry"`
 }
 type group struct {
+	UUID  string
 	Name  string
 	Entry []entry
 	Group []group
@@ -28,56 +43,195 @@ type group struct {
 type root struct {
 	Group []group
 }
+type meta struct {
+	RecycleBinEnabled string
+	RecycleBinUUID    string
+}
 type result struct {
 	XMLName xml.Name `xml:"KeePassFile"`
+	Meta    meta
 	Root    []root
 }
 
 type data struct {
-	Account  string
-	Username string
-	Password string
+	UUID         string
+	Account      string
+	Username     string
+	Password     string
+	URL          string
+	Notes        string
+	Tags         []string
+	Group        string    // full path, e.g. Root/Work/Servers
+	Modified     time.Time // zero when unknown
+	Expired      bool
+	Disabled     bool // the account can't log in, AD dumps only
+	InRecycleBin bool
+	Field        string // protected custom field holding the secret, empty for the password
+	Revision     int    // 0 for the current entry, 1 for the previous revision in the history and so on
+	Hash         string // precomputed hash, when only the hash is known (NTLM dumps)
+}
+
+// label names the entry, and the field or revision the secret comes from
+func (v data) label() string {
+	label := v.Account
+	if v.Field != "" {
+		label += " / " + v.Field
+	}
+	if v.Revision > 0 {
+		label += fmt.Sprintf(" / history -%d", v.Revision)
+		if !v.Modified.IsZero() {
+			label += " (" + v.Modified.Format("2006-01-02") + ")"
+		}
+	}
+	return label
+}
+
+// parseOptions says what, beside the current password, becomes a record to check
+type parseOptions struct {
+	recycleBin string // UUID of the recycle bin group
+	history    bool   // every password in the entry history
+	fields     bool   // every protected custom field
 }
 
-func (gruppo group) parseGroup() []data {
+// recycleBin returns the UUID of the recycle bin group, if enabled
+func (database result) recycleBin() string {
+	if strings.EqualFold(database.Meta.RecycleBinEnabled, "false") {
+		return ""
+	}
+	return database.Meta.RecycleBinUUID
+}
+
+// standard fields of an entry, every other string is a custom field
+var standardFields = map[string]bool{"Title": true, "UserName": true, "Password": true, "URL": true, "Notes": true}
+
+// parseGroup walks the group and its subgroups, parent is the path of the containing group
+func (gruppo group) parseGroup(parent string, inRecycleBin bool, opts parseOptions) []data {
 	//fmt.Printf("group: %v\n", gruppo.Group[j].Name)
 	var informazioni []data
+	path := gruppo.Name
+	if parent != "" {
+		path = parent + "/" + gruppo.Name
+	}
+	if opts.recycleBin != "" && gruppo.UUID == opts.recycleBin {
+		inRecycleBin = true
+	}
 	for z := 0; z < len(gruppo.Entry); z++ {
 		//fmt.Printf("UUID  : %v\n", gruppo.Entry[z].UUID)
 		var informazione data
-		for w := 0; w < len(gruppo.Entry[z].SingleEntries); w++ {
-			v := gruppo.Entry[z].SingleEntries[w]
-			if v.Key == "Password" {
-				informazione.Password = v.Value
-			}
-			if v.Key == "UserName" {
-				informazione.Username = v.Value
-			}
-			if v.Key == "Title" {
-				informazione.Account = v.Value
-			}
-		}
-		informazioni = append(informazioni, informazione)
+		informazione.Group = path
+		informazione.InRecycleBin = inRecycleBin
+		informazioni = append(informazioni, gruppo.Entry[z].parseEntry(informazione, opts)...)
 	}
 	for z := 0; z < len(gruppo.Group); z++ {
-		informazioni = append(informazioni, gruppo.Group[z].parseGroup()...)
+		informazioni = append(informazioni, gruppo.Group[z].parseGroup(path, inRecycleBin, opts)...)
 	}
 	return informazioni
 }
 
-func main() {
+// parseEntry returns the record of the entry, followed by its protected fields and history if requested
+func (e entry) parseEntry(informazione data, opts parseOptions) []data {
+	informazione.UUID = e.UUID
+	informazione.Tags = splitTags(e.Tags)
+	informazione.Modified = parseKeePassTime(e.Times.LastModificationTime)
+	if strings.EqualFold(e.Times.Expires, "true") {
+		expiry := parseKeePassTime(e.Times.ExpiryTime)
+		informazione.Expired = !expiry.IsZero() && expiry.Before(time.Now())
+	}
+	var fields []singleEntry
+	for w := 0; w < len(e.SingleEntries); w++ {
+		v := e.SingleEntries[w]
+		if v.Key == "Password" {
+			informazione.Password = v.Value.Text
+		}
+		if v.Key == "UserName" {
+			informazione.Username = v.Value.Text
+		}
+		if v.Key == "Title" {
+			informazione.Account = v.Value.Text
+		}
+		if v.Key == "URL" {
+			informazione.URL = v.Value.Text
+		}
+		if v.Key == "Notes" {
+			informazione.Notes = v.Value.Text
+		}
+		if !standardFields[v.Key] && strings.EqualFold(v.Value.ProtectInMemory, "true") && v.Value.Text != "" {
+			fields = append(fields, v)
+		}
+	}
+
+	informazioni := []data{informazione}
+	if opts.fields {
+		for _, v := range fields {
+			field := informazione
+			field.Field = v.Key
+			field.Password = v.Value.Text
+			informazioni = append(informazioni, field)
+		}
+	}
+	if opts.history {
+		// KeePass keeps the history from the oldest to the newest revision
+		noHistory := opts
+		noHistory.history = false
+		for h := len(e.History) - 1; h >= 0; h-- {
+			old := data{Group: informazione.Group, InRecycleBin: informazione.InRecycleBin}
+			for _, revision := range e.History[h].parseEntry(old, noHistory) {
+				revision.Revision = len(e.History) - h
+				if revision.Account == "" {
+					revision.Account = informazione.Account
+				}
+				informazioni = append(informazioni, revision)
+			}
+		}
+	}
+	return informazioni
+}
+
+// splitTags accepts both separators used by KeePass over the years
+func splitTags(tags string) []string {
+	var result []string
+	for _, tag := range strings.FieldsFunc(tags, func(r rune) bool { return r == ';' || r == ',' }) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+// parseKeePassTime reads ISO 8601 times (XML export, KDBX 3) or base64 seconds since year 1 (KDBX 4)
+func parseKeePassTime(value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t
+	}
+	raw, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(raw) != 8 {
+		return time.Time{}
+	}
+	// 62135596800 seconds between 0001-01-01 and the Unix epoch
+	seconds := int64(binary.LittleEndian.Uint64(raw))
+	return time.Unix(seconds-62135596800, 0).UTC()
+}
+
+// keepassFile is the opened database, kept to write the remediation markers back
+type keepassFile struct {
+	content []byte        // XML export, or inner XML of the KDBX with plaintext values
+	kdbx    *kdbxDatabase // nil for XML exports
+}
+
+// loadKeePass reads a KDBX database or a KeePass XML export
+func loadKeePass(path string, keyFile string, opts parseOptions) ([]data, *keepassFile, error) {
 	var database result
 	var informazioni []data
-	//	testInfo := []data{{"test", "test", "password"}}
 
 	// Open our xmlFile
-	xmlFile, err := os.Open("Database.xml")
+	xmlFile, err := os.Open(path)
 	if err != nil {
-		fmt.Println(err)
+		return nil, nil, err
 	}
 
-	fmt.Println("Successfully Opened Database.xml")
-
 	/*
 		data := `
 		<Database>
@@ -96,52 +250,188 @@ func main() {
 	data, _ := ioutil.ReadAll(xmlFile)
 	// defer the closing of our xmlFile so that we can parse it later on
 	defer xmlFile.Close()
+	file := &keepassFile{}
+	if isKDBX(data) {
+		password, err := readMasterPassword(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		file.kdbx, err = openKDBX(data, password, keyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		data = file.kdbx.content
+	}
+	file.content = data
 	err = xml.Unmarshal(data, &database)
 	if err != nil {
-		fmt.Printf("error: %v", err)
-		return
+		return nil, nil, err
 	}
 
 	//fmt.Printf("XMLName: %#v\n", database.XMLName)
+	opts.recycleBin = database.recycleBin()
 	for i := 0; i < len(database.Root); i++ {
 		//fmt.Printf("group: %v\n", database.Root[i])
 		for j := 0; j < len(database.Root[i].Group); j++ {
-			informazioni = append(informazioni, database.Root[i].Group[j].parseGroup()...)
+			informazioni = append(informazioni, database.Root[i].Group[j].parseGroup("", false, opts)...)
 		}
 	}
+	return informazioni, file, nil
+}
 
-	//	informazioni = testInfo
+func main() {
+	var informazioni []data
+	//	testInfo := []data{{"test", "test", "password"}}
 
-	for i := 0; i < len(informazioni); i++ {
-		v := informazioni[i]
-		//fmt.Printf("[%s] : %s = %s \n", v.Account, v.Username, v.Password)
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(runCheck(os.Args[2:]))
+	}
+
+	var source checkerFlags
+	source.register(flag.CommandLine)
+	serve := flag.String("serve", "", "serve the -dataset as a range API mirror on this address (e.g. :8080)")
+	keyFile := flag.String("keyfile", "", "key file of the KDBX database")
+	importFormat := flag.String("import", "auto", "database format: auto, keepass, bitwarden, 1pux, csv, pass or secretsdump")
+	report := flag.Bool("report", false, "full hygiene report: breaches, reuse, weak, old and empty passwords")
+	minEntropy := flag.Float64("min-entropy", 50, "passwords with fewer estimated bits of entropy are weak")
+	format := flag.String("format", "text", "output format: text, json, csv or sarif")
+	history := flag.Bool("history", false, "also check every password in the entries history")
+	fields := flag.Bool("fields", false, "also check every protected custom field (API keys, PINs...)")
+	var filter entryFilter
+	flag.Var((*stringList)(&filter.include), "include-group", "only check this group and its subgroups (path or glob, repeatable)")
+	flag.Var((*stringList)(&filter.exclude), "exclude-group", "skip this group and its subgroups (path or glob, repeatable)")
+	flag.BoolVar(&filter.skipRecycleBin, "skip-recycle-bin", false, "skip the entries in the recycle bin")
+	maxAge := flag.Duration("max-age", 365*24*time.Hour, "passwords not changed for longer are old (0 disables)")
+	mark := flag.String("mark", "", "write a copy of the KeePass database here, with the breached entries tagged, annotated and expired")
+	overwrite := flag.Bool("overwrite", false, "let -mark replace an existing file, the database itself included")
+	statePath := flag.String("state", "", "encrypted audit state: only changed or stale entries are looked up, changes since the last audit are reported")
+	stateKeyFile := flag.String("state-key", defaultStateKey(), "key of the -state file, created if missing")
+	recheck := flag.Duration("recheck-after", 7*24*time.Hour, "with -state, look up unchanged entries again after this long")
+	flag.Parse()
+
+	dbPath := "Database.xml"
+	if flag.NArg() > 0 {
+		dbPath = flag.Arg(0)
+	}
 
-		data := []byte(v.Password)
-		hash := fmt.Sprintf("%X", sha1.Sum(data))
-		short := hash[:5]
-		long := hash[5:]
+	if *mark != "" {
+		if err := checkMarkOutput(*mark, *overwrite); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-		res, err := http.Get("https://api.pwnedpasswords.com/range/" + short)
+	if *serve != "" {
+		if source.dataset == "" {
+			log.Fatal("-serve needs a -dataset")
+		}
+		checker, _, err := source.open()
 		if err != nil {
 			log.Fatal(err)
 		}
-		body, err := ioutil.ReadAll(res.Body)
-		res.Body.Close()
+		log.Printf("serving %s (%s) on %s", source.dataset, source.mode, *serve)
+		log.Fatal(http.ListenAndServe(*serve, newRangeServer(source.mode, checker)))
+	}
+
+	// Open our database: KDBX, KeePass XML export or another password manager export
+	opts := parseOptions{history: *history, fields: *fields}
+	imp, err := selectImporter(*importFormat, dbPath, *keyFile, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	informazioni, err = imp.load(dbPath)
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	keepass, _ := imp.(*keepassImporter)
+	if *mark != "" && keepass == nil {
+		log.Fatalf("-mark needs a KeePass database, not %s", imp.name())
+	}
+
+	if *format == "text" {
+		fmt.Printf("Successfully Opened %s (%s)\n", dbPath, imp.name())
+	}
+
+	// the dumps only have NT hashes, the mode goes without saying
+	modeSet := false
+	flag.Visit(func(f *flag.Flag) { modeSet = modeSet || f.Name == "mode" })
+	if _, dump := imp.(secretsdumpImporter); dump && !modeSet {
+		source.mode = hashNTLM
+	}
+	checker, closeChecker, err := source.open()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closeChecker()
+
+	//	informazioni = testInfo
+	scope := newAuditScope(informazioni, filter, opts)
+	informazioni = filter.apply(informazioni)
+
+	hashes := make([]string, len(informazioni))
+	for i, v := range informazioni {
+		if v.Hash != "" && source.mode != hashNTLM {
+			log.Fatalf("%s only has NT hashes, use -mode ntlm", dbPath)
+		}
+		hashes[i] = hashOf(source.mode, v)
+	}
+	var pwned []int
+	var changes []string
+	var gone []data
+	if *statePath != "" {
+		state, err := loadState(*statePath, *stateKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		previous := state.Audited
+		var looked int
+		pwned, changes, gone, looked, err = state.check(checker, informazioni, hashes, scope, source.workers, *recheck, time.Now())
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := state.save(*statePath); err != nil {
+			log.Fatal(err)
+		}
+		if *format == "text" && !previous.IsZero() {
+			fmt.Printf("Looked up %d of %d entries, last audit %s\n", looked, len(informazioni), previous.Format("2006-01-02 15:04"))
+		}
+	} else {
+		pwned, err = lookupAll(checker, hashes, source.workers)
 		if err != nil {
 			log.Fatal(err)
 		}
-		bodyStr := string(body[:len(body)])
-		entries := strings.Split(bodyStr, "\r\n")
+	}
+	var findings []finding
+	if *report {
+		a := audit{minEntropy: *minEntropy, maxAge: *maxAge, now: time.Now()}
+		findings = a.run(informazioni, pwned)
+	} else {
+		for i, v := range informazioni {
+			//fmt.Printf("[%s] : %s = %s \n", v.Account, v.Username, v.Password)
+			findings = append(findings, finding{data: v, Pwned: pwned[i]})
+		}
+	}
+	for i := range changes {
+		findings[i].Change = changes[i]
+	}
+	// breached at the last audit, and gone from the database since
+	for _, v := range gone {
+		findings = append(findings, finding{data: v, Change: changeFixed})
+	}
+	if err := writeFindings(os.Stdout, *format, findings, *report); err != nil {
+		log.Fatal(err)
+	}
 
-		for l := 0; l < len(entries); l++ {
-			spl := strings.Split(entries[l], ":")
-			pwd := spl[0]
-			num := spl[1]
-			if long == pwd {
-				fmt.Printf("CAMBIARE PWD PER [ %s ] : %s risultati\n", v.Account, num)
-			}
+	if *mark != "" {
+		marked, err := writeMarked(keepass.file, *mark, pwnedMarks(findings), time.Now())
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *format == "text" {
+			fmt.Printf("Marked %d entries in %s\n", marked, *mark)
 		}
+	}
 
-		time.Sleep(2000 * time.Millisecond)
+	if code := exitCode(findings); code != 0 {
+		os.Exit(code)
 	}
 }
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const keepassExport = `<?xml version="1.0" encoding="utf-8" standalone="yes"?>
+<KeePassFile>
+	<Meta>
+		<RecycleBinEnabled>False</RecycleBinEnabled>
+		<RecycleBinUUID>YmluYmluYmluYmluYmluYg==</RecycleBinUUID>
+	</Meta>
+	<Root>
+		<Group>
+			<UUID>cm9vdHJvb3Ryb290cm9vdA==</UUID>
+			<Name>Root</Name>
+			<Entry>
+				<UUID>c2VydmVyc2VydmVyc2VydmVy</UUID>
+				<Tags>prod; ssh,db</Tags>
+				<Times>
+					<LastModificationTime>2024-03-01T08:00:00Z</LastModificationTime>
+					<Expires>True</Expires>
+					<ExpiryTime>2020-01-01T00:00:00Z</ExpiryTime>
+				</Times>
+				<String><Key>Title</Key><Value>server</Value></String>
+				<String><Key>UserName</Key><Value>root</Value></String>
+				<String><Key>Password</Key><Value ProtectInMemory="True">current</Value></String>
+				<String><Key>API key</Key><Value ProtectInMemory="True">sk-123</Value></String>
+				<String><Key>Comment</Key><Value>not protected</Value></String>
+				<String><Key>Empty</Key><Value ProtectInMemory="True"></Value></String>
+				<History>
+					<Entry>
+						<UUID>c2VydmVyc2VydmVyc2VydmVy</UUID>
+						<Times><LastModificationTime>2022-01-01T00:00:00Z</LastModificationTime></Times>
+						<String><Key>Title</Key><Value>server (old name)</Value></String>
+						<String><Key>Password</Key><Value ProtectInMemory="True">oldest</Value></String>
+						<String><Key>API key</Key><Value ProtectInMemory="True">sk-000</Value></String>
+					</Entry>
+					<Entry>
+						<UUID>c2VydmVyc2VydmVyc2VydmVy</UUID>
+						<Times><LastModificationTime>2023-01-01T00:00:00Z</LastModificationTime></Times>
+						<String><Key>Password</Key><Value ProtectInMemory="True">previous</Value></String>
+					</Entry>
+				</History>
+			</Entry>
+			<Group>
+				<UUID>YmluYmluYmluYmluYmluYg==</UUID>
+				<Name>Trash</Name>
+				<Entry>
+					<UUID>dHJhc2h0cmFzaHRyYXNodHJh</UUID>
+					<String><Key>Title</Key><Value>deleted</Value></String>
+					<String><Key>Password</Key><Value>gone</Value></String>
+				</Entry>
+			</Group>
+		</Group>
+	</Root>
+</KeePassFile>`
+
+func loadExport(t *testing.T, opts parseOptions) []data {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "export.xml")
+	if err := ioutil.WriteFile(path, []byte(keepassExport), 0600); err != nil {
+		t.Fatal(err)
+	}
+	informazioni, file, err := loadKeePass(path, "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.kdbx != nil {
+		t.Fatal("XML export opened as KDBX")
+	}
+	return informazioni
+}
+
+func TestParseEntry(t *testing.T) {
+	informazioni := loadExport(t, parseOptions{})
+	if len(informazioni) != 2 {
+		t.Fatalf("got %d records, want server and deleted: %+v", len(informazioni), informazioni)
+	}
+	server, deleted := informazioni[0], informazioni[1]
+	if server.Account != "server" || server.Username != "root" || server.Password != "current" || server.Group != "Root" {
+		t.Errorf("server: %+v", server)
+	}
+	if len(server.Tags) != 3 || server.Tags[0] != "prod" || server.Tags[2] != "db" {
+		t.Errorf("tags: %q", server.Tags)
+	}
+	if !server.Expired || server.Modified.Format("2006-01-02") != "2024-03-01" {
+		t.Errorf("expired %v, modified %v", server.Expired, server.Modified)
+	}
+	// the recycle bin is disabled, Trash is an ordinary group
+	if deleted.Group != "Root/Trash" || deleted.InRecycleBin {
+		t.Errorf("deleted: %+v", deleted)
+	}
+}
+
+func TestParseEntryHistoryAndFields(t *testing.T) {
+	informazioni := loadExport(t, parseOptions{history: true, fields: true})
+	want := []struct {
+		field    string
+		revision int
+		account  string
+		password string
+	}{
+		{"", 0, "server", "current"},
+		{"API key", 0, "server", "sk-123"},
+		// newest revision first, the account comes from the entry when the revision has none
+		{"", 1, "server", "previous"},
+		{"", 2, "server (old name)", "oldest"},
+		{"API key", 2, "server (old name)", "sk-000"},
+		{"", 0, "deleted", "gone"},
+	}
+	if len(informazioni) != len(want) {
+		t.Fatalf("got %d records, want %d: %+v", len(informazioni), len(want), informazioni)
+	}
+	for i, w := range want {
+		v := informazioni[i]
+		if v.Field != w.field || v.Revision != w.revision || v.Account != w.account || v.Password != w.password {
+			t.Errorf("record %d: got %q/%q rev %d = %q, want %q/%q rev %d = %q",
+				i, v.Account, v.Field, v.Revision, v.Password, w.account, w.field, w.revision, w.password)
+		}
+	}
+	previous := informazioni[2]
+	if previous.UUID != informazioni[0].UUID || previous.Group != "Root" || previous.Modified.Format("2006") != "2023" {
+		t.Errorf("previous: %+v", previous)
+	}
+	if previous.Username != "" || previous.Expired {
+		t.Errorf("revision inherits the current username or expiry: %+v", previous)
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// finding is everything we know about the hygiene of one entry
+type finding struct {
+	data
+	Pwned    int
+	Empty    bool
+	Entropy  float64
+	Weak     bool
+	Old      bool
+	ReusedBy int    // how many other entries share the same password
+	Change   string // with -state: new, still or fixed since the last audit
+}
+
+type audit struct {
+	minEntropy float64
+	maxAge     time.Duration
+	now        time.Time
+}
+
+// run checks every entry, pwned holds the breach count of the entry with the same index
+func (a audit) run(informazioni []data, pwned []int) []finding {
+	findings := make([]finding, len(informazioni))
+	// reuse is grouped by hash, so the password itself is never kept around as a key
+	reuse := make(map[string][]int)
+	for i, v := range informazioni {
+		f := finding{data: v, Pwned: pwned[i]}
+		if v.Field != "" || v.Revision > 0 {
+			// old revisions and custom fields only matter if breached
+			findings[i] = f
+			continue
+		}
+		if v.Hash != "" {
+			// only the NT hash is known: no strength estimate, but reuse and empty passwords show
+			f.Empty = strings.EqualFold(v.Hash, emptyNTHash)
+		} else {
+			f.Empty = v.Password == ""
+			if !f.Empty {
+				f.Entropy = passwordEntropy(v.Password)
+				f.Weak = f.Entropy < a.minEntropy
+			}
+		}
+		if !f.Empty {
+			key := reuseKey(v)
+			reuse[key] = append(reuse[key], i)
+		}
+		f.Old = a.maxAge > 0 && !v.Modified.IsZero() && a.now.Sub(v.Modified) > a.maxAge
+		findings[i] = f
+	}
+	for _, indexes := range reuse {
+		for _, i := range indexes {
+			findings[i].ReusedBy = len(indexes) - 1
+		}
+	}
+	return findings
+}
+
+type issue struct {
+	id   string
+	text string
+}
+
+// reuseKey groups identical passwords by hash
+func reuseKey(v data) string {
+	if v.Hash != "" {
+		return v.Hash
+	}
+	return sha1Hex(v.Password)
+}
+
+func (f finding) issues() []issue {
+	var issues []issue
+	if f.Pwned > 0 {
+		issues = append(issues, issue{"pwned", fmt.Sprintf("pwned %d times", f.Pwned)})
+	}
+	if f.Empty {
+		issues = append(issues, issue{"empty", "empty"})
+	}
+	if f.Weak {
+		issues = append(issues, issue{"weak", fmt.Sprintf("weak (%.0f bits)", f.Entropy)})
+	}
+	if f.ReusedBy > 0 {
+		issues = append(issues, issue{"reused", fmt.Sprintf("reused by %d other entries", f.ReusedBy)})
+	}
+	if f.Old {
+		issues = append(issues, issue{"old", "not changed since " + f.Modified.Format("2006-01-02")})
+	}
+	return issues
+}
+
+func (f finding) issueTexts() []string {
+	var texts []string
+	for _, i := range f.issues() {
+		texts = append(texts, i.text)
+	}
+	return texts
+}
+
+// score goes from 100 (nothing to say) down to 0
+func (f finding) score() int {
+	penalty := 0
+	if f.Pwned > 0 || f.Empty {
+		penalty += 100
+	}
+	if f.Weak {
+		penalty += 50
+	}
+	if f.ReusedBy > 0 {
+		penalty += 50
+	}
+	if f.Old {
+		penalty += 25
+	}
+	if penalty > 100 {
+		return 0
+	}
+	return 100 - penalty
+}
+
+type groupScore struct {
+	Group   string
+	Entries int
+	Issues  int
+	Score   int
+}
+
+func groupScores(findings []finding) []groupScore {
+	scores := make(map[string]*groupScore)
+	var groups []string
+	total := make(map[string]int)
+	for _, f := range findings {
+		if f.Revision > 0 {
+			// the history tells what leaked, not how the group is doing now
+			continue
+		}
+		s, ok := scores[f.Group]
+		if !ok {
+			s = &groupScore{Group: f.Group}
+			scores[f.Group] = s
+			groups = append(groups, f.Group)
+		}
+		s.Entries++
+		if len(f.issues()) > 0 {
+			s.Issues++
+		}
+		total[f.Group] += f.score()
+	}
+	sort.Strings(groups)
+	var result []groupScore
+	for _, g := range groups {
+		s := scores[g]
+		s.Score = total[g] / s.Entries
+		result = append(result, *s)
+	}
+	return result
+}
+
+func printReport(w io.Writer, findings []finding) {
+	fmt.Fprintln(w, "ENTRIES")
+	for _, f := range findings {
+		if issues := f.issueTexts(); len(issues) > 0 {
+			if f.InRecycleBin {
+				issues = append(issues, "in the recycle bin")
+			}
+			if f.Expired {
+				issues = append(issues, "expired")
+			}
+			if f.Disabled {
+				issues = append(issues, "disabled")
+			}
+			fmt.Fprintf(w, "  [ %s ] %s (%s): %s\n", f.Group, f.label(), f.Username, strings.Join(issues, ", "))
+		}
+	}
+
+	fmt.Fprintln(w, "REUSED PASSWORDS")
+	reuse := make(map[string][]string)
+	var hashes []string
+	for _, f := range findings {
+		if f.ReusedBy == 0 {
+			continue
+		}
+		hash := reuseKey(f.data)
+		if _, ok := reuse[hash]; !ok {
+			hashes = append(hashes, hash)
+		}
+		reuse[hash] = append(reuse[hash], f.Group+"/"+f.Account)
+	}
+	for i, hash := range hashes {
+		fmt.Fprintf(w, "  #%d shared by %d entries: %s\n", i+1, len(reuse[hash]), strings.Join(reuse[hash], ", "))
+	}
+
+	fmt.Fprintln(w, "GROUPS")
+	for _, s := range groupScores(findings) {
+		fmt.Fprintf(w, "  %3d/100 %s (%d of %d entries with issues)\n", s.Score, s.Group, s.Issues, s.Entries)
+	}
+}
+
+// printChanges lists what changed since the last audit (-state)
+func printChanges(w io.Writer, findings []finding) {
+	fmt.Fprintln(w, "SINCE THE LAST AUDIT")
+	for _, change := range []struct{ id, text string }{
+		{changeNew, "newly breached"},
+		{changeStill, "still breached"},
+		{changeFixed, "fixed"},
+	} {
+		var labels []string
+		for _, f := range findings {
+			if f.Change == change.id {
+				labels = append(labels, f.Group+"/"+f.label())
+			}
+		}
+		fmt.Fprintf(w, "  %s: %d\n", change.text, len(labels))
+		for _, label := range labels {
+			fmt.Fprintf(w, "    %s\n", label)
+		}
+	}
+}
+
+/*
+passwordEntropy is a rough, zxcvbn inspired, estimate of the bits of entropy.
+
+Starting from length * log2(character pool), the predictable parts are discounted:
+common passwords and words (also in l33t speak) count as a pick from our small
+dictionary, while repeated characters, sequences (abc, 321) and keyboard walks
+(qwerty) count as a quarter of a character each.
+*/
+func passwordEntropy(password string) float64 {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return 0
+	}
+	pool := characterPool(runes)
+	bitsPerChar := math.Log2(float64(pool))
+	dictionaryBits := math.Log2(float64(len(commonWords)))
+
+	if isCommonWord(password) {
+		return dictionaryBits
+	}
+
+	// a dictionary word wrapped by digits and symbols (Password123!)
+	start, end := 0, len(runes)
+	for start < end && !unicode.IsLetter(runes[start]) {
+		start++
+	}
+	for end > start && !unicode.IsLetter(runes[end-1]) {
+		end--
+	}
+	if end-start >= 4 && isCommonWord(string(runes[start:end])) {
+		rest := append(append([]rune{}, runes[:start]...), runes[end:]...)
+		return dictionaryBits + predictableLength(rest)*bitsPerChar
+	}
+	return predictableLength(runes) * bitsPerChar
+}
+
+func characterPool(runes []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < 128:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	pool := 0
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if symbol {
+		pool += 33
+	}
+	if other {
+		pool += 100
+	}
+	return pool
+}
+
+var keyboardRows = []string{"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./"}
+
+// predictableLength counts repeated, sequential and adjacent keys as a quarter of a character
+func predictableLength(runes []rune) float64 {
+	length := 0.0
+	for i, r := range runes {
+		if i == 0 {
+			length++
+			continue
+		}
+		prev := unicode.ToLower(runes[i-1])
+		cur := unicode.ToLower(r)
+		if cur == prev || cur == prev+1 || cur == prev-1 || keyboardAdjacent(prev, cur) {
+			length += 0.25
+		} else {
+			length++
+		}
+	}
+	return length
+}
+
+func keyboardAdjacent(a, b rune) bool {
+	for _, row := range keyboardRows {
+		i := strings.IndexRune(row, a)
+		if i < 0 {
+			continue
+		}
+		if j := strings.IndexRune(row, b); j >= 0 && (j == i+1 || j == i-1) {
+			return true
+		}
+	}
+	return false
+}
+
+var leetReplacer = strings.NewReplacer("0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "@", "a", "$", "s", "!", "i")
+
+func isCommonWord(word string) bool {
+	word = strings.ToLower(word)
+	return commonWords[word] || commonWords[leetReplacer.Replace(word)]
+}
+
+// the top of every leaked password list, plus words that end up in too many passwords
+var commonWords = map[string]bool{
+	"123456": true, "123456789": true, "12345678": true, "12345": true, "1234567": true,
+	"1234567890": true, "111111": true, "000000": true, "123123": true, "654321": true,
+	"password": true, "passw0rd": true, "qwerty": true, "qwertyuiop": true, "asdfgh": true,
+	"abc123": true, "iloveyou": true, "admin": true, "administrator": true, "welcome": true,
+	"letmein": true, "monkey": true, "dragon": true, "master": true, "login": true,
+	"princess": true, "sunshine": true, "football": true, "baseball": true, "shadow": true,
+	"superman": true, "batman": true, "trustno1": true, "starwars": true, "whatever": true,
+	"freedom": true, "secret": true, "changeme": true, "default": true, "guest": true,
+	"root": true, "toor": true, "test": true, "summer": true, "winter": true,
+	"spring": true, "autumn": true, "hello": true, "charlie": true, "michael": true,
+	"jessica": true, "pokemon": true, "computer": true, "internet": true, "keepass": true,
+	"ciao": true, "amore": true, "juventus": true, "milan": true, "inter": true,
+	"napoli": true, "roma": true, "lazio": true, "italia": true, "forzaitalia": true,
+	"password1": true, "qwerty123": true, "1q2w3e4r": true, "zaq12wsx": true, "qazwsx": true,
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPasswordEntropy(t *testing.T) {
+	dictionary := math.Log2(float64(len(commonWords)))
+	tests := []struct {
+		password string
+		want     float64
+	}{
+		{"", 0},
+		{"password", dictionary},
+		{"P@ssw0rd", dictionary},
+		{"QWERTY", dictionary},
+		// the word plus 1, 2 (sequence), 3 (sequence) and ! from a pool of 95
+		{"Password123!", dictionary + 2.5*math.Log2(95)},
+		// a, then eleven repeats
+		{"aaaaaaaaaaaa", 3.75 * math.Log2(26)},
+		// z, x (adjacent), then c, v, b (adjacent)
+		{"zxcvb", 2 * math.Log2(26)},
+		{"x7#Kp9!mQz2$", 12 * math.Log2(95)},
+	}
+	for _, tt := range tests {
+		if got := passwordEntropy(tt.password); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("passwordEntropy(%q) = %.2f, want %.2f", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestAuditReuse(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	informazioni := []data{
+		{Group: "Root", Account: "mail", Password: "x7#Kp9!mQz2$", Modified: now.AddDate(-2, 0, 0)},
+		{Group: "Root", Account: "bank", Password: "x7#Kp9!mQz2$"},
+		{Group: "Root/Work", Account: "vpn", Password: "x7#Kp9!mQz2$"},
+		{Group: "Root/Work", Account: "wiki", Password: "password"},
+		{Group: "Root/Work", Account: "printer", Password: ""},
+		{Group: "Root/Work", Account: "nas", Password: ""},
+		// history and custom fields never count as reuse
+		{Group: "Root", Account: "mail", Password: "x7#Kp9!mQz2$", Revision: 1},
+		{Group: "Root", Account: "mail", Password: "x7#Kp9!mQz2$", Field: "PIN"},
+		// NTLM dumps are grouped by hash, with or without the password
+		{Group: "AD", Account: "alice", Hash: ntHash("hunter2")},
+		{Group: "AD", Account: "bob", Hash: ntHash("hunter2")},
+		{Group: "AD", Account: "guest", Hash: emptyNTHash},
+	}
+	pwned := make([]int, len(informazioni))
+	pwned[3] = 100
+	findings := audit{minEntropy: 50, maxAge: 365 * 24 * time.Hour, now: now}.run(informazioni, pwned)
+
+	reusedBy := []int{2, 2, 2, 0, 0, 0, 0, 0, 1, 1, 0}
+	for i, f := range findings {
+		if f.ReusedBy != reusedBy[i] {
+			t.Errorf("%s: reused by %d, want %d", f.label(), f.ReusedBy, reusedBy[i])
+		}
+	}
+	if !findings[0].Old || findings[1].Old {
+		t.Errorf("old: mail %v, bank %v", findings[0].Old, findings[1].Old)
+	}
+	if !findings[3].Weak || findings[0].Weak || !findings[4].Empty || !findings[10].Empty || findings[8].Empty {
+		t.Errorf("weak or empty: %+v", findings)
+	}
+	if findings[6].Weak || findings[6].Entropy != 0 {
+		t.Errorf("history revision estimated: %+v", findings[6])
+	}
+	if s := findings[3].score(); s != 0 {
+		t.Errorf("pwned and weak scores %d", s)
+	}
+	if s := findings[1].score(); s != 50 {
+		t.Errorf("reused scores %d", s)
+	}
+
+	var out bytes.Buffer
+	printReport(&out, findings)
+	report := out.String()
+	for _, want := range []string{
+		"  #1 shared by 3 entries: Root/mail, Root/bank, Root/Work/vpn\n",
+		"  #2 shared by 2 entries: AD/alice, AD/bob\n",
+		"  [ Root/Work ] wiki (): pwned 100 times, weak (",
+		"  58/100 Root (2 of 3 entries with issues)\n",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report misses %q:\n%s", want, report)
+		}
+	}
+	if strings.Contains(report, "#3") {
+		t.Errorf("empty passwords grouped as reuse:\n%s", report)
+	}
+}
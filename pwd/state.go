@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+/*
+	Incremental audits.
+
+	The state file remembers, for every record, a keyed hash of its password and
+	when it was last looked up: the next run only looks up the changed, new or
+	stale records and tells what changed since the previous audit. The file is
+	encrypted with AES-GCM, the key is kept in a separate file (created on first
+	use), so a copy of the state alone says nothing about the passwords.
+*/
+
+// what changed since the previous audit
+const (
+	changeNew   = "new"   // breached, and not breached (or not there) at the last audit
+	changeStill = "still" // breached now and at the last audit
+	changeFixed = "fixed" // breached at the last audit, the password has been changed or the entry deleted since
+)
+
+type stateRecord struct {
+	Fingerprint string    `json:"fingerprint"` // HMAC of the password hash
+	Checked     time.Time `json:"checked"`
+	Pwned       int       `json:"pwned"`
+
+	// to name the entry once it's gone
+	UUID     string `json:"uuid,omitempty"`
+	Group    string `json:"group,omitempty"`
+	Account  string `json:"account,omitempty"`
+	Username string `json:"username,omitempty"`
+	Field    string `json:"field,omitempty"`
+	Revision int    `json:"revision,omitempty"`
+}
+
+type auditState struct {
+	Audited time.Time              `json:"audited"`
+	Records map[string]stateRecord `json:"records"`
+
+	key []byte
+}
+
+// defaultStateKey is pwd/state.key in the user configuration directory
+func defaultStateKey() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "pwd", "state.key")
+}
+
+// readStateKey reads the key file, or creates it with a random key
+func readStateKey(path string) ([]byte, error) {
+	key, err := ioutil.ReadFile(path)
+	if err == nil {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("%s: the state key must be 32 bytes", path)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	return key, ioutil.WriteFile(path, key, 0600)
+}
+
+// subkey derives independent keys for encryption and fingerprints
+func subkey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// loadState opens the state file, a missing file is an empty state
+func loadState(path string, keyFile string) (*auditState, error) {
+	key, err := readStateKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	s := &auditState{Records: make(map[string]stateRecord), key: key}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(content) < aead.NonceSize() {
+		return nil, fmt.Errorf("%s: truncated state file", path)
+	}
+	plain, err := aead.Open(nil, content[:aead.NonceSize()], content[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: wrong state key or corrupted state file", path)
+	}
+	if err := json.Unmarshal(plain, s); err != nil {
+		return nil, err
+	}
+	if s.Records == nil {
+		s.Records = make(map[string]stateRecord)
+	}
+	return s, nil
+}
+
+func (s *auditState) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(subkey(s.key, "state encryption"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// save encrypts the state, through a temporary file and a rename
+func (s *auditState) save(path string) error {
+	plain, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	aead, err := s.aead()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	content := aead.Seal(nonce, nonce, plain, nil)
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".pwd-state-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *auditState) fingerprint(hash string) string {
+	mac := hmac.New(sha256.New, subkey(s.key, "password fingerprint"))
+	mac.Write([]byte(hash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// entryKey identifies the entry the record comes from
+func entryKey(uuid, group, account, username string) string {
+	if uuid == "" {
+		// exports without identifiers
+		return group + "/" + account + "/" + username
+	}
+	return uuid
+}
+
+/*
+stateKey identifies the record across runs: the entry UUID, plus the field, and
+for a history revision its modification time, or the fingerprint when the time
+is unknown. Not the revision number, that shifts at every password change.
+*/
+func stateKey(v data, fingerprint string) string {
+	key := entryKey(v.UUID, v.Group, v.Account, v.Username)
+	if v.Field != "" {
+		key += "/" + v.Field
+	}
+	if v.Revision > 0 {
+		if v.Modified.IsZero() {
+			key += "#" + fingerprint
+		} else {
+			key += "@" + v.Modified.UTC().Format(time.RFC3339)
+		}
+	}
+	return key
+}
+
+// auditScope tells which of the previous records this run could have seen
+type auditScope struct {
+	history bool            // the history revisions are checked
+	fields  bool            // the protected custom fields are checked
+	skipped map[string]bool // entryKey of the entries left out by the group and recycle bin filters
+}
+
+// newAuditScope remembers the entries of the whole database the filter leaves out
+func newAuditScope(all []data, filter entryFilter, opts parseOptions) auditScope {
+	scope := auditScope{history: opts.history, fields: opts.fields, skipped: make(map[string]bool)}
+	for _, v := range all {
+		if !filter.keep(v) {
+			scope.skipped[entryKey(v.UUID, v.Group, v.Account, v.Username)] = true
+		}
+	}
+	return scope
+}
+
+func (scope auditScope) covers(r stateRecord) bool {
+	if r.Revision > 0 && !scope.history || r.Field != "" && !scope.fields {
+		return false
+	}
+	return !scope.skipped[entryKey(r.UUID, r.Group, r.Account, r.Username)]
+}
+
+/*
+check looks up the records whose password changed, the new ones and the ones
+last looked up more than recheck ago, and reuses the previous count for the
+others. It returns the breach counts, what changed for every record (nothing on
+the first audit), the records breached at the last audit that are gone from the
+database, and how many records have been looked up. The state is updated, not
+saved: the records scope doesn't cover are kept as they are, the other ones not
+seen in this run are dropped.
+*/
+func (s *auditState) check(checker BreachChecker, informazioni []data, hashes []string, scope auditScope, workers int, recheck time.Duration, now time.Time) ([]int, []string, []data, int, error) {
+	pwned := make([]int, len(informazioni))
+	changes := make([]string, len(informazioni))
+	fingerprints := make([]string, len(informazioni))
+	looked := make([]bool, len(informazioni))
+	var pending []int
+	var pendingHashes []string
+	for i, v := range informazioni {
+		fingerprints[i] = s.fingerprint(hashes[i])
+		previous, ok := s.Records[stateKey(v, fingerprints[i])]
+		if ok && previous.Fingerprint == fingerprints[i] && now.Sub(previous.Checked) < recheck {
+			pwned[i] = previous.Pwned
+			continue
+		}
+		looked[i] = true
+		pending = append(pending, i)
+		pendingHashes = append(pendingHashes, hashes[i])
+	}
+
+	counts, err := lookupAll(checker, pendingHashes, workers)
+	if err != nil {
+		return nil, nil, nil, 0, err
+	}
+	for j, i := range pending {
+		pwned[i] = counts[j]
+	}
+
+	seen := make(map[string]stateRecord)
+	for i, v := range informazioni {
+		key := stateKey(v, fingerprints[i])
+		previous, ok := s.Records[key]
+		wasPwned := ok && previous.Pwned > 0
+		switch {
+		case s.Audited.IsZero():
+			// nothing to compare with
+		case pwned[i] > 0 && wasPwned && previous.Fingerprint == fingerprints[i]:
+			changes[i] = changeStill
+		case pwned[i] > 0:
+			changes[i] = changeNew
+		case wasPwned && previous.Fingerprint != fingerprints[i]:
+			changes[i] = changeFixed
+		}
+		record := stateRecord{Fingerprint: fingerprints[i], Checked: previous.Checked, Pwned: pwned[i],
+			UUID: v.UUID, Group: v.Group, Account: v.Account, Username: v.Username, Field: v.Field, Revision: v.Revision}
+		if looked[i] {
+			record.Checked = now
+		}
+		seen[key] = record
+	}
+
+	var gone []data
+	for key, previous := range s.Records {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		if !scope.covers(previous) {
+			// filtered out, or not checked in this run: not a change
+			seen[key] = previous
+			continue
+		}
+		if previous.Pwned == 0 {
+			continue
+		}
+		gone = append(gone, data{UUID: previous.UUID, Group: previous.Group, Account: previous.Account,
+			Username: previous.Username, Field: previous.Field, Revision: previous.Revision})
+	}
+	sort.Slice(gone, func(i, j int) bool { return gone[i].label() < gone[j].label() })
+	s.Records = seen
+	s.Audited = now
+	return pwned, changes, gone, len(pending), nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStateCheck(t *testing.T) {
+	dir := t.TempDir()
+	statePath, keyPath := filepath.Join(dir, "state"), filepath.Join(dir, "state.key")
+	checker := newStaticChecker(hashSHA1, map[string]int{"password": 42, "hunter2": 7})
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	all := auditScope{history: true, fields: true}
+	run := func(now time.Time, informazioni []data) ([]int, []string, []data, int) {
+		return runState(t, statePath, keyPath, checker, all, now, informazioni)
+	}
+
+	// mail has one revision in the history, web is breached
+	first := []data{
+		{UUID: "mail", Account: "mail", Password: "correct horse", Modified: start},
+		{UUID: "mail", Account: "mail", Password: "hunter2", Revision: 1, Modified: start.Add(-time.Hour)},
+		{UUID: "web", Account: "web", Password: "password"},
+	}
+	pwned, changes, gone, looked := run(start, first)
+	// nothing to compare with at the first audit
+	if looked != 3 || pwned[1] != 7 || pwned[2] != 42 || changes[1] != "" || changes[2] != "" || len(gone) != 0 {
+		t.Fatalf("first run: pwned %v changes %v gone %v looked %d", pwned, changes, gone, looked)
+	}
+
+	// the mail password changes: the old revisions move down the history, web is deleted
+	second := []data{
+		{UUID: "mail", Account: "mail", Password: "battery staple", Modified: start.Add(time.Hour)},
+		{UUID: "mail", Account: "mail", Password: "correct horse", Revision: 1, Modified: start},
+		{UUID: "mail", Account: "mail", Password: "hunter2", Revision: 2, Modified: start.Add(-time.Hour)},
+	}
+	pwned, changes, gone, looked = run(start.Add(time.Hour), second)
+	// the new password, and the previous one now in the history; hunter2 keeps its record
+	if looked != 2 {
+		t.Errorf("second run: looked up %d, want 2", looked)
+	}
+	if pwned[2] != 7 || changes[1] != "" || changes[2] != changeStill {
+		t.Errorf("second run: pwned %v changes %v", pwned, changes)
+	}
+	if len(gone) != 1 || gone[0].UUID != "web" || gone[0].Account != "web" {
+		t.Errorf("second run: gone %+v, want web", gone)
+	}
+
+	// web is reported once, then forgotten
+	_, _, gone, looked = run(start.Add(2*time.Hour), second)
+	if len(gone) != 0 || looked != 0 {
+		t.Errorf("third run: gone %+v looked %d", gone, looked)
+	}
+}
+
+func runState(t *testing.T, statePath, keyPath string, checker BreachChecker, scope auditScope, now time.Time, informazioni []data) ([]int, []string, []data, int) {
+	t.Helper()
+	state, err := loadState(statePath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := make([]string, len(informazioni))
+	for i, v := range informazioni {
+		hashes[i] = hashOf(hashSHA1, v)
+	}
+	pwned, changes, gone, looked, err := state.check(checker, informazioni, hashes, scope, 2, 24*time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.save(statePath); err != nil {
+		t.Fatal(err)
+	}
+	return pwned, changes, gone, looked
+}
+
+// a run on a subset of the database neither fixes nor forgets what it leaves out
+func TestStateCheckFiltered(t *testing.T) {
+	dir := t.TempDir()
+	statePath, keyPath := filepath.Join(dir, "state"), filepath.Join(dir, "state.key")
+	checker := newStaticChecker(hashSHA1, map[string]int{"password": 42, "hunter2": 7, "1234": 3})
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	database := []data{
+		{UUID: "mail", Account: "mail", Group: "Root", Password: "hunter2"},
+		{UUID: "mail", Account: "mail", Group: "Root", Password: "1234", Field: "PIN"},
+		{UUID: "mail", Account: "mail", Group: "Root", Password: "password", Revision: 1, Modified: start.Add(-time.Hour)},
+		{UUID: "vpn", Account: "vpn", Group: "Root/Work", Password: "password"},
+		{UUID: "old", Account: "old", Group: "Root/Recycle Bin", Password: "password", InRecycleBin: true},
+	}
+	opts := parseOptions{history: true, fields: true}
+	full := newAuditScope(database, entryFilter{}, opts)
+	runState(t, statePath, keyPath, checker, full, start, database)
+
+	// only Root/Work, no recycle bin, no history or fields: vpn is the only record
+	filter := entryFilter{include: []string{"Root/Work"}, skipRecycleBin: true}
+	scope := newAuditScope(database, filter, parseOptions{})
+	_, changes, gone, looked := runState(t, statePath, keyPath, checker, scope, start.Add(time.Hour), filter.apply(database))
+	if len(gone) != 0 || len(changes) != 1 || changes[0] != changeStill || looked != 0 {
+		t.Fatalf("filtered run: changes %v gone %+v looked %d", changes, gone, looked)
+	}
+
+	// back to the whole database: nothing was forgotten, vpn has a new password, old has been deleted
+	database[3].Password = "battery staple"
+	_, changes, gone, looked = runState(t, statePath, keyPath, checker, full, start.Add(2*time.Hour), database[:4])
+	if looked != 1 {
+		t.Errorf("full run: looked up %d, the filtered out records must keep their state", looked)
+	}
+	for i, want := range []string{changeStill, changeStill, changeStill, changeFixed} {
+		if changes[i] != want {
+			t.Errorf("full run: %s is %q, want %q", database[i].label(), changes[i], want)
+		}
+	}
+	if len(gone) != 1 || gone[0].UUID != "old" {
+		t.Errorf("full run: gone %+v, want old", gone)
+	}
+}
@@ -0,0 +1,435 @@
+//go:build ignore
+
+// gen_kdbx writes the KDBX fixtures used by kdbx_test.go, from the pwd directory:
+//
+//	go run testdata/gen_kdbx.go
+//
+// It follows the KeePass file format on its own, with golang.org/x/crypto
+// for Argon2id, Salsa20 and ChaCha20, so the fixtures do not depend on the
+// reader they are testing. Seeds and keys are derived from fixed labels, the
+// output is the same at every run.
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/salsa20"
+)
+
+const password = "correct horse"
+
+var (
+	cipherAES256   = unhex("31c1f2e6bf714350be5805216afc5aff")
+	cipherChaCha20 = unhex("d6038a2b8b6f4cb5a524339a31dbb59a")
+	kdfAES         = unhex("c9d9f39a628a4460bf740d08c18a4fea")
+	kdfArgon2id    = unhex("9e298b1956db4773b23dfc3ec6f0a1e6")
+)
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// fixed returns n bytes derived from label
+func fixed(label string, n int) []byte {
+	var out []byte
+	for i := 0; len(out) < n; i++ {
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%d", label, i)))
+		out = append(out, sum[:]...)
+	}
+	return out[:n]
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+func u64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	return b
+}
+
+// database template, the protected values are %s placeholders filled in document order
+const database = `<?xml version="1.0" encoding="utf-8" standalone="yes"?>
+<KeePassFile>
+	<Meta>
+		<Generator>gen_kdbx</Generator>%s
+		<RecycleBinEnabled>True</RecycleBinEnabled>
+		<RecycleBinUUID>cmVjeWNsZWJpbnJlY3ljbA==</RecycleBinUUID>
+	</Meta>
+	<Root>
+		<Group>
+			<UUID>cm9vdHJvb3Ryb290cm9vdA==</UUID>
+			<Name>Root</Name>
+			<Entry>
+				<UUID>bWFpbG1haWxtYWlsbWFpbA==</UUID>
+				<Tags>personal</Tags>
+				<Times>
+					<LastModificationTime>%s</LastModificationTime>
+					<Expires>False</Expires>
+				</Times>
+				<String>
+					<Key>Title</Key>
+					<Value>Mail</Value>
+				</String>
+				<String>
+					<Key>UserName</Key>
+					<Value>alice</Value>
+				</String>
+				<String>
+					<Key>Password</Key>
+					<Value Protected="True">%s</Value>
+				</String>
+				<String>
+					<Key>PIN</Key>
+					<Value Protected="True">%s</Value>
+				</String>
+				<History>
+					<Entry>
+						<UUID>bWFpbG1haWxtYWlsbWFpbA==</UUID>
+						<Times>
+							<LastModificationTime>%s</LastModificationTime>
+						</Times>
+						<String>
+							<Key>Title</Key>
+							<Value>Mail</Value>
+						</String>
+						<String>
+							<Key>Password</Key>
+							<Value Protected="True">%s</Value>
+						</String>
+					</Entry>
+				</History>
+			</Entry>
+			<Group>
+				<UUID>cmVjeWNsZWJpbnJlY3ljbA==</UUID>
+				<Name>Recycle Bin</Name>
+				<Entry>
+					<UUID>b2xkb2xkb2xkb2xkb2xkbw==</UUID>
+					<String>
+						<Key>Title</Key>
+						<Value>Old &amp; gone</Value>
+					</String>
+					<String>
+						<Key>Password</Key>
+						<Value Protected="True">%s</Value>
+					</String>
+				</Entry>
+			</Group>
+		</Group>
+	</Root>
+</KeePassFile>`
+
+// render fills the template, encrypting the protected values with the inner stream
+func render(headerHash string, when func(time.Time) string, keystream []byte) []byte {
+	protect := func(plain string) string {
+		out := make([]byte, len(plain))
+		for i := range out {
+			out[i] = plain[i] ^ keystream[i]
+		}
+		keystream = keystream[len(plain):]
+		return base64.StdEncoding.EncodeToString(out)
+	}
+	modified := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	// arguments are evaluated in order, as the values appear in the document
+	return []byte(fmt.Sprintf(database, headerHash,
+		when(modified), protect("hunter2"), protect("4321"),
+		when(modified.AddDate(-1, 0, 0)), protect("password1"),
+		protect("trashed")))
+}
+
+func isoTime(t time.Time) string { return t.Format(time.RFC3339) }
+
+// KDBX 4 stores the seconds since year 1, base64 encoded
+func binaryTime(t time.Time) string {
+	return base64.StdEncoding.EncodeToString(u64(uint64(t.Unix() + 62135596800)))
+}
+
+func gzipped(data []byte) []byte {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write(data)
+	w.Close()
+	return b.Bytes()
+}
+
+func aesKDF(composite, seed []byte, rounds uint64) []byte {
+	block, err := aes.NewCipher(seed)
+	if err != nil {
+		log.Fatal(err)
+	}
+	key := append([]byte{}, composite...)
+	for i := uint64(0); i < rounds; i++ {
+		block.Encrypt(key[:16], key[:16])
+		block.Encrypt(key[16:], key[16:])
+	}
+	sum := sha256.Sum256(key)
+	return sum[:]
+}
+
+func aesCBC(key, iv, data []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pad := aes.BlockSize - len(data)%aes.BlockSize
+	data = append(append([]byte{}, data...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	out := make([]byte, len(data))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, data)
+	return out
+}
+
+func chacha(key, nonce, data []byte) []byte {
+	stream, err := chacha20.NewUnauthenticatedCipher(key, nonce)
+	if err != nil {
+		log.Fatal(err)
+	}
+	out := make([]byte, len(data))
+	stream.XORKeyStream(out, data)
+	return out
+}
+
+func composite(keyData []byte) []byte {
+	h := sha256.New()
+	sum := sha256.Sum256([]byte(password))
+	h.Write(sum[:])
+	h.Write(keyData)
+	return h.Sum(nil)
+}
+
+// header serializes the outer header, 16 bit sizes before KDBX 4
+func header(major, minor uint16, fields [][]byte) []byte {
+	var b bytes.Buffer
+	b.Write(u32(0x9AA2D903))
+	b.Write(u32(0xB54BFB67))
+	binary.Write(&b, binary.LittleEndian, minor)
+	binary.Write(&b, binary.LittleEndian, major)
+	for _, f := range fields {
+		b.WriteByte(f[0])
+		if major >= 4 {
+			b.Write(u32(uint32(len(f) - 1)))
+		} else {
+			binary.Write(&b, binary.LittleEndian, uint16(len(f)-1))
+		}
+		b.Write(f[1:])
+	}
+	return b.Bytes()
+}
+
+func field(id byte, data []byte) []byte { return append([]byte{id}, data...) }
+
+// kdbx3 is a KDBX 3.1 database: AES-256, AES-KDF, gzip, Salsa20 inner stream
+func kdbx3() []byte {
+	masterSeed := fixed("kdbx3 master seed", 32)
+	transformSeed := fixed("kdbx3 transform seed", 32)
+	iv := fixed("kdbx3 iv", 16)
+	streamKey := fixed("kdbx3 stream key", 32)
+	startBytes := fixed("kdbx3 start bytes", 32)
+	const rounds = 1000
+
+	head := header(3, 1, [][]byte{
+		field(2, cipherAES256),
+		field(3, u32(1)),
+		field(4, masterSeed),
+		field(5, transformSeed),
+		field(6, u64(rounds)),
+		field(7, iv),
+		field(8, streamKey),
+		field(9, startBytes),
+		field(10, u32(2)),
+		field(0, []byte("\r\n\r\n")),
+	})
+	headerHash := sha256.Sum256(head)
+
+	salsaKey := sha256.Sum256(streamKey)
+	keystream := make([]byte, 1024)
+	salsa20.XORKeyStream(keystream, keystream, []byte{0xE8, 0x30, 0x09, 0x4B, 0x97, 0x20, 0x5D, 0x2A}, &salsaKey)
+	xml := render("\n\t\t<HeaderHash>"+base64.StdEncoding.EncodeToString(headerHash[:])+"</HeaderHash>", isoTime, keystream)
+
+	// hashed block stream: one block with the data, then the empty one
+	content := gzipped(xml)
+	var plain bytes.Buffer
+	plain.Write(startBytes)
+	sum := sha256.Sum256(content)
+	plain.Write(u32(0))
+	plain.Write(sum[:])
+	plain.Write(u32(uint32(len(content))))
+	plain.Write(content)
+	plain.Write(u32(1))
+	plain.Write(make([]byte, 32))
+	plain.Write(u32(0))
+
+	transformed := aesKDF(composite(nil), transformSeed, rounds)
+	key := sha256.Sum256(append(append([]byte{}, masterSeed...), transformed...))
+	return append(head, aesCBC(key[:], iv, plain.Bytes())...)
+}
+
+// variant dictionary entries, in the given order
+type variant struct {
+	kind  byte
+	key   string
+	value []byte
+}
+
+func variantDictionary(entries []variant) []byte {
+	b := bytes.NewBuffer([]byte{0x00, 0x01})
+	for _, e := range entries {
+		b.WriteByte(e.kind)
+		b.Write(u32(uint32(len(e.key))))
+		b.WriteString(e.key)
+		b.Write(u32(uint32(len(e.value))))
+		b.Write(e.value)
+	}
+	b.WriteByte(0)
+	return b.Bytes()
+}
+
+func hmacKey(index uint64, key []byte) []byte {
+	sum := sha512.Sum512(append(u64(index), key...))
+	return sum[:]
+}
+
+/*
+kdbx4 is a KDBX 4.0 database with a ChaCha20 inner stream, either
+ChaCha20 + Argon2id + gzip or AES-256 + AES-KDF without compression
+*/
+func kdbx4(name string, cipherID []byte, keyData []byte) []byte {
+	masterSeed := fixed(name+" master seed", 32)
+	streamKey := fixed(name+" stream key", 64)
+	kdfSeed := fixed(name+" kdf seed", 32)
+
+	var iv, kdf, transformed []byte
+	compression := uint32(1)
+	if bytes.Equal(cipherID, cipherChaCha20) {
+		const iterations, memory, parallelism = 2, 64, 2
+		iv = fixed(name+" iv", 12)
+		kdf = variantDictionary([]variant{
+			{0x42, "$UUID", kdfArgon2id},
+			{0x05, "I", u64(iterations)},
+			{0x05, "M", u64(memory * 1024)},
+			{0x04, "P", u32(parallelism)},
+			{0x42, "S", kdfSeed},
+			{0x04, "V", u32(0x13)},
+		})
+		transformed = argon2.IDKey(composite(keyData), kdfSeed, iterations, memory, parallelism, 32)
+	} else {
+		const rounds = 1000
+		iv = fixed(name+" iv", 16)
+		kdf = variantDictionary([]variant{
+			{0x42, "$UUID", kdfAES},
+			{0x05, "R", u64(rounds)},
+			{0x42, "S", kdfSeed},
+		})
+		transformed = aesKDF(composite(keyData), kdfSeed, rounds)
+		compression = 0
+	}
+	head := header(4, 0, [][]byte{
+		field(2, cipherID),
+		field(3, u32(compression)),
+		field(4, masterSeed),
+		field(7, iv),
+		field(11, kdf),
+		field(0, []byte("\r\n\r\n")),
+	})
+
+	innerKey := sha512.Sum512(streamKey)
+	keystream := chacha(innerKey[:32], innerKey[32:44], make([]byte, 1024))
+	var plain bytes.Buffer
+	for _, f := range [][]byte{field(1, u32(3)), field(2, streamKey), field(3, []byte("\x00attachment")), field(0, nil)} {
+		plain.WriteByte(f[0])
+		plain.Write(u32(uint32(len(f) - 1)))
+		plain.Write(f[1:])
+	}
+	plain.Write(render("", binaryTime, keystream))
+	content := plain.Bytes()
+	if compression == 1 {
+		content = gzipped(content)
+	}
+
+	key := sha256.Sum256(append(append([]byte{}, masterSeed...), transformed...))
+	var encrypted []byte
+	if bytes.Equal(cipherID, cipherChaCha20) {
+		encrypted = chacha(key[:], iv, content)
+	} else {
+		encrypted = aesCBC(key[:], iv, content)
+	}
+
+	// header hash and HMAC, then the HMAC block stream closed by an empty block
+	macKey := sha512.Sum512(append(append(append([]byte{}, masterSeed...), transformed...), 1))
+	out := bytes.NewBuffer(append([]byte{}, head...))
+	headerHash := sha256.Sum256(head)
+	out.Write(headerHash[:])
+	mac := hmac.New(sha256.New, hmacKey(^uint64(0), macKey[:]))
+	mac.Write(head)
+	out.Write(mac.Sum(nil))
+	for i, block := range [][]byte{encrypted, nil} {
+		mac := hmac.New(sha256.New, hmacKey(uint64(i), macKey[:]))
+		mac.Write(u64(uint64(i)))
+		mac.Write(u32(uint32(len(block))))
+		mac.Write(block)
+		out.Write(mac.Sum(nil))
+		out.Write(u32(uint32(len(block))))
+		out.Write(block)
+	}
+	return out.Bytes()
+}
+
+// keyFile is an XML key file, version 2.0
+func keyFile(key []byte) []byte {
+	digits := strings.ToUpper(hex.EncodeToString(key))
+	var groups []string
+	for i := 0; i < len(digits); i += 8 {
+		groups = append(groups, digits[i:i+8])
+	}
+	sum := sha256.Sum256(key)
+	return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?>
+<KeyFile>
+	<Meta>
+		<Version>2.0</Version>
+	</Meta>
+	<Key>
+		<Data Hash="%X">
+			%s
+			%s
+		</Data>
+	</Key>
+</KeyFile>
+`, sum[:4], strings.Join(groups[:4], " "), strings.Join(groups[4:], " ")))
+}
+
+func main() {
+	key := fixed("key file", 32)
+	files := map[string][]byte{
+		"testdata/kdbx3-aes.kdbx":              kdbx3(),
+		"testdata/kdbx4-aes.kdbx":              kdbx4("kdbx4 aes", cipherAES256, nil),
+		"testdata/kdbx4-chacha20-keyfile.kdbx": kdbx4("kdbx4 chacha20", cipherChaCha20, key),
+		"testdata/kdbx4.keyx":                  keyFile(key),
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(name, content, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
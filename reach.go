@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// reachability, like the port states but for any network
+const (
+	reachSuccess     = "success"     // connected, or for UDP an answer came back
+	reachRefused     = "refused"     // TCP reset, ICMP port unreachable for UDP, nobody listening on the Unix socket
+	reachTimeout     = "timeout"     // no answer in time; for UDP the port may just be open and silent
+	reachDNS         = "dns"         // the host name could not be resolved
+	reachUnreachable = "unreachable" // no route to the host or network
+	reachError       = "error"       // anything else: missing socket file, permission, unknown zone...
+)
+
+type reachResult struct {
+	Network string        `json:"network"`
+	Address string        `json:"address"`
+	Status  string        `json:"status"`
+	Latency time.Duration `json:"latency"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// reachCheck dials Address on Network: tcp, tcp4, tcp6, udp, udp4, udp6 or unix
+type reachCheck struct {
+	Network string
+	Address string        // host:port, [fe80::1%eth0]:22 for a link local address, or the path of a Unix socket
+	Timeout time.Duration // 1 second when zero
+	Payload []byte        // sent to UDP ports, an empty datagram when nil
+}
+
+// parseReach reads network:address, like udp:127.0.0.1:53 or unix:/run/app.sock
+func parseReach(spec string) (reachCheck, error) {
+	i := strings.Index(spec, ":")
+	if i < 0 {
+		return reachCheck{}, fmt.Errorf("invalid check %q, expected network:address", spec)
+	}
+	c := reachCheck{Network: spec[:i], Address: spec[i+1:]}
+	switch c.Network {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6", "unix":
+	default:
+		return c, fmt.Errorf("invalid check %q: unsupported network %q", spec, c.Network)
+	}
+	if c.Address == "" {
+		return c, fmt.Errorf("invalid check %q: no address", spec)
+	}
+	return c, nil
+}
+
+// reachStatus classifies a dial, read or write error
+func reachStatus(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return reachSuccess
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return reachRefused
+	case errors.As(err, &dnsErr):
+		return reachDNS
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return reachUnreachable
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return reachTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return reachTimeout
+	}
+	return reachError
+}
+
+// checkZone tells early that the zone of a link local address doesn't exist, the dial error would be obscure
+func checkZone(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	i := strings.LastIndex(host, "%")
+	if i < 0 {
+		return nil
+	}
+	zone := host[i+1:]
+	if _, err := net.InterfaceByName(zone); err != nil {
+		// numeric zones are interface indexes
+		var index int
+		if _, scanErr := fmt.Sscanf(zone, "%d", &index); scanErr != nil {
+			return fmt.Errorf("zone %s: %v", zone, err)
+		}
+		if _, err := net.InterfaceByIndex(index); err != nil {
+			return fmt.Errorf("zone %s: %v", zone, err)
+		}
+	}
+	return nil
+}
+
+/*
+check dials the address and measures the latency. TCP and Unix sockets are
+reachable once connected. UDP has no handshake: a connected socket sends the
+payload and waits for an answer until the timeout. Linux reports the ICMP port
+unreachable of a closed port as ECONNREFUSED on the next read, so refused is
+reliable, but timeout only means that nothing answered: the port is open and
+ignoring the payload, or filtered.
+*/
+func (c reachCheck) check(ctx context.Context) reachResult {
+	r := reachResult{Network: c.Network, Address: c.Address}
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	fail := func(err error) reachResult {
+		r.Status = reachStatus(err)
+		r.Error = err.Error()
+		return r
+	}
+	switch c.Network {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6":
+		if err := checkZone(c.Address); err != nil {
+			return fail(err)
+		}
+	case "unix":
+	default:
+		return fail(fmt.Errorf("unsupported network %q", c.Network))
+	}
+
+	var dialer net.Dialer
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		r.Latency = time.Since(start)
+		return fail(err)
+	}
+	defer conn.Close()
+
+	if strings.HasPrefix(c.Network, "udp") {
+		deadline, _ := ctx.Deadline()
+		conn.SetDeadline(deadline)
+		if _, err := conn.Write(c.Payload); err != nil {
+			r.Latency = time.Since(start)
+			return fail(err)
+		}
+		buf := make([]byte, 1500)
+		_, err := conn.Read(buf)
+		r.Latency = time.Since(start)
+		if err != nil {
+			return fail(err)
+		}
+	} else {
+		r.Latency = time.Since(start)
+	}
+	r.Status = reachSuccess
+	return r
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestReachCheck(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+
+	// echo
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			udp.WriteTo(buf[:n], addr)
+		}
+	}()
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	closed, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.LocalAddr().String()
+	closed.Close()
+
+	dir := t.TempDir()
+	sock := filepath.Join(dir, "app.sock")
+	unix, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close()
+	// the file of a dead server is still there
+	stale := filepath.Join(dir, "stale.sock")
+	dead, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead.(*net.UnixListener).SetUnlinkOnClose(false)
+	dead.Close()
+
+	tests := []struct {
+		check reachCheck
+		want  string
+	}{
+		{reachCheck{Network: "tcp", Address: tcp.Addr().String()}, reachSuccess},
+		{reachCheck{Network: "tcp", Address: closedPortAddr(t)}, reachRefused},
+		{reachCheck{Network: "tcp", Address: "nonexistent.invalid:80"}, reachDNS},
+		{reachCheck{Network: "udp", Address: udp.LocalAddr().String(), Payload: []byte("ping")}, reachSuccess},
+		{reachCheck{Network: "udp", Address: closedAddr}, reachRefused},
+		{reachCheck{Network: "udp", Address: silent.LocalAddr().String(), Timeout: 200 * time.Millisecond}, reachTimeout},
+		{reachCheck{Network: "unix", Address: sock}, reachSuccess},
+		{reachCheck{Network: "unix", Address: stale}, reachRefused},
+		{reachCheck{Network: "unix", Address: filepath.Join(dir, "missing.sock")}, reachError},
+		{reachCheck{Network: "tcp6", Address: "[fe80::1%nosuchif0]:22"}, reachError},
+		{reachCheck{Network: "sctp", Address: "127.0.0.1:1"}, reachError},
+	}
+	for _, test := range tests {
+		r := test.check.check(context.Background())
+		if r.Status != test.want {
+			t.Errorf("%s %s: got %s (%s), want %s", test.check.Network, test.check.Address, r.Status, r.Error, test.want)
+		}
+		if r.Status == reachSuccess && r.Latency <= 0 {
+			t.Errorf("%s %s: no latency", test.check.Network, test.check.Address)
+		}
+	}
+}
+
+func closedPortAddr(t *testing.T) string {
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(closedPort(t)))
+}
+
+func TestParseReach(t *testing.T) {
+	c, err := parseReach("tcp6:[fe80::1%eth0]:22")
+	if err != nil || c.Network != "tcp6" || c.Address != "[fe80::1%eth0]:22" {
+		t.Errorf("got %+v %v", c, err)
+	}
+	if c, err := parseReach("unix:/run/app.sock"); err != nil || c.Address != "/run/app.sock" {
+		t.Errorf("got %+v %v", c, err)
+	}
+	for _, spec := range []string{"", "127.0.0.1:53", "sctp:host:1", "udp:"} {
+		if _, err := parseReach(spec); err == nil {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+}
+
+func TestWaitForReach(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "app.sock")
+	opened := make(chan net.Listener, 1)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		l, _ := net.Listen("unix", sock)
+		opened <- l
+	}()
+	defer func() {
+		if l := <-opened; l != nil {
+			l.Close()
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := WaitForReach(ctx, reachCheck{Network: "unix", Address: sock}, WithBackoff(10*time.Millisecond, 20*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunChecksReach(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "app.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	s := serviceConfig{Name: "app", Reach: []string{"unix:" + sock, "tcp:" + closedPortAddr(t)}}
+	results := runChecks(context.Background(), s, time.Second)
+	if len(results) != 2 || !results[0].OK || results[1].OK || results[1].Error == "" {
+		t.Errorf("got %+v", results)
+	}
+}
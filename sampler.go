@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// resourceSample is the resource use of a process at a point in time
+type resourceSample struct {
+	PID        int           `json:"pid"`
+	Time       time.Time     `json:"time"`
+	CPUPercent float64       `json:"cpu_percent"` // since the previous sample (0 for the first one), 100 is one full core
+	CPUTime    time.Duration `json:"cpu_time"`    // user + system, since the start
+	RSS        int64         `json:"rss"`         // resident memory, bytes
+	FDs        int           `json:"fds"`         // open file descriptors
+	Threads    int           `json:"threads"`
+	State      string        `json:"state"`
+	ReadBytes  uint64        `json:"read_bytes"`  // from storage, 0 when /proc/PID/io is not readable
+	WriteBytes uint64        `json:"write_bytes"` // to storage
+}
+
+// resourceLimits are the thresholds of the alerts, zero means no limit
+type resourceLimits struct {
+	CPUPercent float64
+	RSS        int64
+	FDs        int
+	Threads    int
+}
+
+// exceeded describes every limit the sample is over
+func (l resourceLimits) exceeded(s resourceSample) []string {
+	var over []string
+	if l.CPUPercent > 0 && s.CPUPercent > l.CPUPercent {
+		over = append(over, fmt.Sprintf("cpu %.1f%% > %.1f%%", s.CPUPercent, l.CPUPercent))
+	}
+	if l.RSS > 0 && s.RSS > l.RSS {
+		over = append(over, fmt.Sprintf("rss %d > %d bytes", s.RSS, l.RSS))
+	}
+	if l.FDs > 0 && s.FDs > l.FDs {
+		over = append(over, fmt.Sprintf("fds %d > %d", s.FDs, l.FDs))
+	}
+	if l.Threads > 0 && s.Threads > l.Threads {
+		over = append(over, fmt.Sprintf("threads %d > %d", s.Threads, l.Threads))
+	}
+	return over
+}
+
+// sample reads the counters of the process, CPUPercent is left to the caller (it needs two samples)
+func (fs procFS) sample(pid int) (resourceSample, error) {
+	s := resourceSample{PID: pid, Time: time.Now()}
+	dir := strconv.Itoa(pid)
+
+	content, err := ioutil.ReadFile(fs.path(dir, "stat"))
+	if err != nil {
+		return s, err
+	}
+	fields, err := statFields(string(content))
+	if err != nil || len(fields) < 19 {
+		return s, fmt.Errorf("%s: malformed stat", fs.path(dir, "stat"))
+	}
+	utime, _ := strconv.ParseInt(fields[12], 10, 64)
+	stime, _ := strconv.ParseInt(fields[13], 10, 64)
+	s.CPUTime = time.Duration(utime+stime) * time.Second / clockTicks
+	s.Threads, _ = strconv.Atoi(fields[18])
+	s.State = fields[1]
+
+	// statm: size resident shared text lib data dt, in pages
+	if statm, err := ioutil.ReadFile(fs.path(dir, "statm")); err == nil {
+		if fields := strings.Fields(string(statm)); len(fields) > 1 {
+			pages, _ := strconv.ParseInt(fields[1], 10, 64)
+			s.RSS = pages * int64(os.Getpagesize())
+		}
+	}
+
+	if fds, err := ioutil.ReadDir(fs.path(dir, "fd")); err == nil {
+		s.FDs = len(fds)
+	}
+
+	// io needs the same user (or root), and is not there without task accounting
+	if counters, err := ioutil.ReadFile(fs.path(dir, "io")); err == nil {
+		for _, line := range strings.Split(string(counters), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				continue
+			}
+			value, _ := strconv.ParseUint(fields[1], 10, 64)
+			switch fields[0] {
+			case "read_bytes:":
+				s.ReadBytes = value
+			case "write_bytes:":
+				s.WriteBytes = value
+			}
+		}
+	}
+	return s, nil
+}
+
+/*
+sampleResources samples the process every interval until the context is done or
+the process exits (or is a zombie), then closes the channel. When a sample is over the limits,
+alert gets it with the reasons, before it is sent on the channel.
+*/
+func (fs procFS) sampleResources(ctx context.Context, pid int, interval time.Duration, limits resourceLimits, alert func(resourceSample, []string)) <-chan resourceSample {
+	samples := make(chan resourceSample)
+	go func() {
+		defer close(samples)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var previous resourceSample
+		for {
+			s, err := fs.sample(pid)
+			if err != nil || s.State == "Z" || s.State == "X" {
+				return
+			}
+			if !previous.Time.IsZero() {
+				if elapsed := s.Time.Sub(previous.Time); elapsed > 0 {
+					s.CPUPercent = float64(s.CPUTime-previous.CPUTime) / float64(elapsed) * 100
+				}
+			}
+			previous = s
+			if over := limits.exceeded(s); len(over) > 0 && alert != nil {
+				alert(s, over)
+			}
+			select {
+			case samples <- s:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return samples
+}
+
+func runSample(args []string) int {
+	fs := flag.NewFlagSet("sample", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: utils sample [flags]\n\nflags:\n")
+		fs.PrintDefaults()
+	}
+	pid := fs.Int("pid", 0, "process to sample")
+	name := fs.String("process", "", "sample the first process with this name, instead of -pid")
+	interval := fs.Duration("interval", time.Second, "time between samples")
+	count := fs.Int("count", 0, "stop after this many samples, 0 until the process exits")
+	asJSON := fs.Bool("json", false, "print a JSON object per sample")
+	var limits resourceLimits
+	var rssMB int64
+	fs.Float64Var(&limits.CPUPercent, "max-cpu", 0, "alert over this CPU percent (100 is one core)")
+	fs.Int64Var(&rssMB, "max-rss-mb", 0, "alert over this resident memory, in MB")
+	fs.IntVar(&limits.FDs, "max-fds", 0, "alert over this many open file descriptors")
+	fs.IntVar(&limits.Threads, "max-threads", 0, "alert over this many threads")
+	fs.Parse(args)
+	limits.RSS = rssMB << 20
+
+	if *name != "" {
+		found, err := defaultProcFS.find(processQuery{Name: *name})
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		if len(found) == 0 {
+			log.Printf("no process %s", *name)
+			return 1
+		}
+		*pid = found[0].PID
+	}
+	if *pid <= 0 || *interval <= 0 {
+		fs.Usage()
+		return 2
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	alerts := 0
+	alert := func(s resourceSample, over []string) {
+		alerts++
+		log.Printf("pid %d over the limits: %s", s.PID, strings.Join(over, ", "))
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	n := 0
+	samples := defaultProcFS.sampleResources(ctx, *pid, *interval, limits, alert)
+	for s := range samples {
+		if *asJSON {
+			encoder.Encode(s)
+		} else {
+			fmt.Printf("%s pid %d %s cpu %.1f%% rss %dK fds %d threads %d read %d write %d\n",
+				s.Time.Format("15:04:05"), s.PID, s.State, s.CPUPercent, s.RSS>>10, s.FDs, s.Threads, s.ReadBytes, s.WriteBytes)
+		}
+		n++
+		if *count > 0 && n >= *count {
+			cancel()
+			break
+		}
+	}
+	// not printed, but alert may still be running: wait for the sampler to stop
+	for range samples {
+	}
+	if n == 0 {
+		log.Printf("no process %d", *pid)
+		return 1
+	}
+	if alerts > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSample(t *testing.T) {
+	fs := fakeProc(t)
+	dir := filepath.Join(fs.root, "42")
+	// 150 + 50 ticks of CPU, 10 resident pages
+	stat := "42 (python3) S 1 42 7 0 -1 0 0 0 0 0 150 50 0 0 20 0 3 0 250 0 0\n"
+	files := map[string]string{
+		"stat":  stat,
+		"statm": "1000 10 5 1 0 100 0\n",
+		"io":    "rchar: 9\nwchar: 9\nread_bytes: 4096\nwrite_bytes: 512\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "fd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, fd := range []string{"0", "1", "2", "3"} {
+		if err := os.Symlink("/dev/null", filepath.Join(dir, "fd", fd)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := fs.sample(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.CPUTime != 2*time.Second || s.RSS != 10*int64(os.Getpagesize()) || s.FDs != 4 || s.Threads != 3 || s.State != "S" {
+		t.Errorf("got %+v", s)
+	}
+	if s.ReadBytes != 4096 || s.WriteBytes != 512 {
+		t.Errorf("io %d %d", s.ReadBytes, s.WriteBytes)
+	}
+	if _, err := fs.sample(99); err == nil {
+		t.Error("sample of a missing process")
+	}
+
+	limits := resourceLimits{CPUPercent: 50, RSS: 1, FDs: 4, Threads: 2}
+	s.CPUPercent = 80
+	if over := limits.exceeded(s); len(over) != 3 {
+		t.Errorf("over %q, want cpu, rss and threads", over)
+	}
+	if over := (resourceLimits{}).exceeded(s); len(over) != 0 {
+		t.Errorf("no limits, over %q", over)
+	}
+
+	// a zombie has nothing to sample
+	if err := ioutil.WriteFile(filepath.Join(dir, "stat"), []byte("42 (python3) Z 1 42 7 0 -1 0 0 0 0 0 150 50 0 0 20 0 3 0 250 0 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for range fs.sampleResources(context.Background(), 42, time.Millisecond, resourceLimits{}, nil) {
+		t.Error("sample of a zombie")
+	}
+}
+
+func TestSampleResources(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "while :; do :; done")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	alerts := 0
+	var samples []resourceSample
+	for s := range defaultProcFS.sampleResources(ctx, cmd.Process.Pid, 100*time.Millisecond, resourceLimits{CPUPercent: 10}, func(resourceSample, []string) { alerts++ }) {
+		samples = append(samples, s)
+		if len(samples) == 4 {
+			cancel()
+		}
+	}
+	if len(samples) != 4 {
+		t.Fatalf("%d samples", len(samples))
+	}
+	if samples[0].CPUPercent != 0 {
+		t.Errorf("first sample cpu %.1f%%, want 0", samples[0].CPUPercent)
+	}
+	// a busy loop, on a loaded machine it may still get less than a core
+	if alerts == 0 || samples[3].CPUPercent <= 10 {
+		t.Errorf("cpu %.1f%%, %d alerts", samples[3].CPUPercent, alerts)
+	}
+
+	// the channel is closed when the process exits
+	cmd.Process.Kill()
+	cmd.Wait()
+	done := make(chan bool)
+	go func() {
+		for range defaultProcFS.sampleResources(context.Background(), cmd.Process.Pid, 10*time.Millisecond, resourceLimits{}, nil) {
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("still sampling after the exit")
+	}
+}
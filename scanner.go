@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// port states, as nmap names them
+const (
+	portOpen     = "open"     // the connection succeeded
+	portClosed   = "closed"   // refused: the host is there, nobody listens
+	portFiltered = "filtered" // no answer or unreachable, most likely a firewall dropping the packets
+	portError    = "error"    // the host could not be resolved, or another local error
+)
+
+type scanResult struct {
+	Host    string        `json:"host"`
+	Port    int           `json:"port"`
+	State   string        `json:"state"`
+	Latency time.Duration `json:"latency"`
+	Error   string        `json:"error,omitempty"`
+	Service *serviceInfo  `json:"service,omitempty"` // open ports, with Probe
+}
+
+// dialPort connects to host:port and tells the state of the port
+func dialPort(ctx context.Context, host string, port int, timeout time.Duration) (scanResult, net.Conn) {
+	r := scanResult{Host: host, Port: port}
+	dialer := net.Dialer{Timeout: timeout}
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	r.Latency = time.Since(start)
+	if err == nil {
+		r.State = portOpen
+		return r, conn
+	}
+	r.Error = err.Error()
+	r.State = portState(err)
+	return r, nil
+}
+
+// portState classifies a dial error
+func portState(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return portClosed
+	case errors.As(err, &dnsErr):
+		return portError
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH), errors.Is(err, context.DeadlineExceeded):
+		return portFiltered
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return portFiltered
+	}
+	return portError
+}
+
+// parsePorts reads lists and ranges like "22,80,8000-8100", and service names like "https"
+func parsePorts(spec string) ([]int, error) {
+	var ports []int
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if _, err := strconv.Atoi(part); err != nil && !strings.Contains(part, "-") {
+			port, err := net.LookupPort("tcp", part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid port %q", part)
+			}
+			ports = append(ports, port)
+			continue
+		}
+		first, last := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			first, last = part[:i], part[i+1:]
+		}
+		from, err1 := strconv.Atoi(first)
+		to, err2 := strconv.Atoi(last)
+		if err1 != nil || err2 != nil || from < 1 || to > 65535 || from > to {
+			return nil, fmt.Errorf("invalid port range %q", part)
+		}
+		for port := from; port <= to; port++ {
+			ports = append(ports, port)
+		}
+	}
+	return ports, nil
+}
+
+// we don't scan more than a /16 at once
+const maxCIDRHosts = 1 << 16
+
+/*
+parseHosts expands host names, addresses and CIDR ranges (also comma separated).
+The network and broadcast addresses of IPv4 ranges are skipped, except for /31
+and /32 which have none.
+*/
+func parseHosts(specs []string) ([]string, error) {
+	var hosts []string
+	for _, spec := range specs {
+		for _, host := range strings.Split(spec, ",") {
+			host = strings.TrimSpace(host)
+			if host == "" {
+				continue
+			}
+			if !strings.Contains(host, "/") {
+				hosts = append(hosts, host)
+				continue
+			}
+			ip, network, err := net.ParseCIDR(host)
+			if err != nil {
+				return nil, err
+			}
+			ones, bits := network.Mask.Size()
+			if bits-ones > 16 {
+				return nil, fmt.Errorf("%s: more than %d hosts", host, maxCIDRHosts)
+			}
+			var expanded []string
+			for ip := ip.Mask(network.Mask); network.Contains(ip); ip = nextIP(ip) {
+				expanded = append(expanded, ip.String())
+			}
+			if ip.To4() != nil && bits-ones > 1 {
+				expanded = expanded[1 : len(expanded)-1]
+			}
+			hosts = append(hosts, expanded...)
+		}
+	}
+	return hosts, nil
+}
+
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+type portScanner struct {
+	Timeout time.Duration // of each dial, 1 second when zero
+	Workers int           // concurrent dials, 100 when zero
+	Rate    float64       // dials per second, zero for no limit
+	Probe   bool          // detect the service behind the open ports (probeService)
+}
+
+/*
+scan dials every port of every host and returns the results in the order of the
+hosts, then by port. When the context is cancelled it stops and returns what it
+has, with the context error.
+*/
+func (s portScanner) scan(ctx context.Context, hosts []string, ports []int) ([]scanResult, error) {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	workers := s.Workers
+	if workers <= 0 {
+		workers = 100
+	}
+	var limit <-chan time.Time
+	if s.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / s.Rate))
+		defer ticker.Stop()
+		limit = ticker.C
+	}
+
+	type target struct {
+		host string
+		port int
+	}
+	targets := make(chan target)
+	go func() {
+		defer close(targets)
+		for _, host := range hosts {
+			for _, port := range ports {
+				select {
+				case targets <- target{host, port}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	var results []scanResult
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range targets {
+				if limit != nil {
+					select {
+					case <-limit:
+					case <-ctx.Done():
+						return
+					}
+				}
+				r, conn := dialPort(ctx, t.host, t.port, timeout)
+				if conn != nil {
+					conn.Close()
+					if s.Probe {
+						info := probeService(ctx, t.host, t.port, timeout)
+						r.Service = &info
+					}
+				}
+				if ctx.Err() != nil {
+					// cancelled mid-dial, the state says nothing about the port
+					return
+				}
+				mu.Lock()
+				results = append(results, r)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	order := make(map[string]int)
+	for i := len(hosts) - 1; i >= 0; i-- {
+		order[hosts[i]] = i
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Host != results[j].Host {
+			return order[results[i].Host] < order[results[j].Host]
+		}
+		return results[i].Port < results[j].Port
+	})
+	return results, ctx.Err()
+}
+
+func runScan(args []string) int {
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: utils scan [flags] host|cidr...\n\nflags:\n")
+		fs.PrintDefaults()
+	}
+	spec := fs.String("ports", "1-1024", "ports to scan: 22,80,8000-8100 or service names")
+	var s portScanner
+	fs.DurationVar(&s.Timeout, "timeout", time.Second, "timeout of each dial")
+	fs.IntVar(&s.Workers, "workers", 100, "concurrent dials")
+	fs.Float64Var(&s.Rate, "rate", 0, "dials per second, 0 for no limit")
+	fs.BoolVar(&s.Probe, "probe", false, "detect the service behind the open ports")
+	all := fs.Bool("all", false, "also print the closed and filtered ports")
+	asJSON := fs.Bool("json", false, "print JSON")
+	fs.Parse(args)
+
+	hosts, err := parseHosts(fs.Args())
+	if err != nil {
+		log.Println(err)
+		return 2
+	}
+	ports, err := parsePorts(*spec)
+	if err != nil {
+		log.Println(err)
+		return 2
+	}
+	if len(hosts) == 0 || len(ports) == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	// on ^C print what we have
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	results, err := s.scan(ctx, hosts, ports)
+	if err != nil {
+		log.Println(err)
+	}
+	var shown []scanResult
+	for _, r := range results {
+		if *all || r.State == portOpen {
+			shown = append(shown, r)
+		}
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(shown)
+	} else {
+		for _, r := range shown {
+			fmt.Printf("%-21s %-8s %s", net.JoinHostPort(r.Host, strconv.Itoa(r.Port)), r.State, r.Latency.Round(time.Microsecond))
+			if r.Error != "" && r.State == portError {
+				fmt.Printf(" %s", r.Error)
+			}
+			if r.Service != nil {
+				fmt.Printf(" %s", r.Service)
+			}
+			fmt.Println()
+		}
+	}
+	if err != nil {
+		return 1
+	}
+	return 0
+}
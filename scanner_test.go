@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// listener opens a port on 127.0.0.1, closed with the test
+func listener(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// closedPort is a port nobody listens on, most likely
+func closedPort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	return port
+}
+
+func TestParsePorts(t *testing.T) {
+	ports, err := parsePorts("22, 8000-8002,https,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int{22, 8000, 8001, 8002, 443}
+	if len(ports) != len(want) {
+		t.Fatalf("got %v, want %v", ports, want)
+	}
+	for i := range want {
+		if ports[i] != want[i] {
+			t.Fatalf("got %v, want %v", ports, want)
+		}
+	}
+	for _, spec := range []string{"9-3", "0", "70000", "1-x", "nosuchservice"} {
+		if _, err := parsePorts(spec); err == nil {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+}
+
+func TestParseHosts(t *testing.T) {
+	hosts, err := parseHosts([]string{"127.0.0.1,localhost", "192.168.1.0/30", "10.0.0.0/31", "::1/127"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"127.0.0.1", "localhost", "192.168.1.1", "192.168.1.2", "10.0.0.0", "10.0.0.1", "::", "::1"}
+	if len(hosts) != len(want) {
+		t.Fatalf("got %v, want %v", hosts, want)
+	}
+	for i := range want {
+		if hosts[i] != want[i] {
+			t.Fatalf("got %v, want %v", hosts, want)
+		}
+	}
+	if _, err := parseHosts([]string{"10.0.0.0/8"}); err == nil {
+		t.Error("a /8 accepted")
+	}
+	if _, err := parseHosts([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("a /33 accepted")
+	}
+}
+
+func TestScan(t *testing.T) {
+	open, closed := listener(t), closedPort(t)
+	s := portScanner{Timeout: time.Second, Workers: 4}
+	results, err := s.scan(context.Background(), []string{"127.0.0.1", "nonexistent.invalid"}, []int{closed, open})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("got %d results, want 4", len(results))
+	}
+	// by host, then by port
+	byPort := map[int]string{open: portOpen, closed: portClosed}
+	for _, r := range results[:2] {
+		if r.Host != "127.0.0.1" || r.State != byPort[r.Port] {
+			t.Errorf("%+v", r)
+		}
+	}
+	if results[0].Port > results[1].Port {
+		t.Errorf("not sorted by port: %d, %d", results[0].Port, results[1].Port)
+	}
+	for _, r := range results[2:] {
+		if r.Host != "nonexistent.invalid" || r.State != portError || r.Error == "" {
+			t.Errorf("%+v", r)
+		}
+	}
+}
+
+func TestScanCancel(t *testing.T) {
+	ports, err := parsePorts("1-2000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	// 100 dials per second, 2000 ports would take 20 seconds
+	results, err := portScanner{Rate: 100}.scan(ctx, []string{"127.0.0.1"}, ports)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error %v, want the deadline", err)
+	}
+	if len(results) > 20 {
+		t.Errorf("%d results after the cancellation", len(results))
+	}
+}
+
+func TestCheckOpenPort(t *testing.T) {
+	open, closed := listener(t), closedPort(t)
+	if ok, err := checkOpenPort("127.0.0.1", strconv.Itoa(open)); !ok || err != nil {
+		t.Errorf("open port: %v %v", ok, err)
+	}
+	// the dial error, not a copy of its text
+	ok, err := checkOpenPort("127.0.0.1", strconv.Itoa(closed))
+	if ok || !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("closed port: %v %v", ok, err)
+	}
+	// a service name is resolved, not rejected
+	if _, err := checkOpenPort("127.0.0.1", "https"); err != nil && !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("service name: %v", err)
+	}
+	if _, err := checkOpenPort("127.0.0.1", "nosuchservice"); err == nil {
+		t.Error("unknown service accepted")
+	}
+}
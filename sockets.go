@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// socket is a line of /proc/net/tcp, tcp6, udp or udp6
+type socket struct {
+	Proto      string `json:"proto"` // tcp, tcp6, udp or udp6
+	LocalIP    net.IP `json:"local_ip"`
+	LocalPort  int    `json:"local_port"`
+	RemoteIP   net.IP `json:"remote_ip"`
+	RemotePort int    `json:"remote_port"`
+	State      string `json:"state"` // LISTEN, ESTABLISHED... (UDP sockets are CLOSE unless connected)
+	UID        int    `json:"uid"`
+	Inode      uint64 `json:"inode"`
+	PID        int    `json:"pid"` // 0 when the owner can't be seen (another user, without root)
+}
+
+var socketProtos = []string{"tcp", "tcp6", "udp", "udp6"}
+
+// include/net/tcp_states.h
+var tcpStates = map[string]string{
+	"01": "ESTABLISHED",
+	"02": "SYN_SENT",
+	"03": "SYN_RECV",
+	"04": "FIN_WAIT1",
+	"05": "FIN_WAIT2",
+	"06": "TIME_WAIT",
+	"07": "CLOSE",
+	"08": "CLOSE_WAIT",
+	"09": "LAST_ACK",
+	"0A": "LISTEN",
+	"0B": "CLOSING",
+}
+
+// listening is true for TCP sockets in LISTEN and for bound, unconnected UDP sockets
+func (s socket) listening() bool {
+	if strings.HasPrefix(s.Proto, "udp") {
+		return s.State == "CLOSE" && s.RemotePort == 0
+	}
+	return s.State == "LISTEN"
+}
+
+/*
+parseSocketAddress decodes ADDR:PORT as written by the kernel: the port is big
+endian hex, the address is made of 32 bit words (one for IPv4, four for IPv6)
+printed in host order, that is little endian on every platform we run on.
+*/
+func parseSocketAddress(s string) (net.IP, int, error) {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return nil, 0, fmt.Errorf("malformed address %q", s)
+	}
+	raw, err := hex.DecodeString(s[:i])
+	if err != nil || (len(raw) != 4 && len(raw) != 16) {
+		return nil, 0, fmt.Errorf("malformed address %q", s)
+	}
+	for w := 0; w < len(raw); w += 4 {
+		raw[w], raw[w+1], raw[w+2], raw[w+3] = raw[w+3], raw[w+2], raw[w+1], raw[w]
+	}
+	port, err := strconv.ParseUint(s[i+1:], 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("malformed port %q", s)
+	}
+	return net.IP(raw), int(port), nil
+}
+
+// readSockets parses one of the /proc/net tables
+func (fs procFS) readSockets(proto string) ([]socket, error) {
+	f, err := os.Open(fs.path("net", proto))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var sockets []socket
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		s := socket{Proto: proto, State: fields[3]}
+		if s.LocalIP, s.LocalPort, err = parseSocketAddress(fields[1]); err != nil {
+			return nil, err
+		}
+		if s.RemoteIP, s.RemotePort, err = parseSocketAddress(fields[2]); err != nil {
+			return nil, err
+		}
+		if state, ok := tcpStates[fields[3]]; ok {
+			s.State = state
+		}
+		s.UID, _ = strconv.Atoi(fields[7])
+		s.Inode, _ = strconv.ParseUint(fields[9], 10, 64)
+		sockets = append(sockets, s)
+	}
+	return sockets, scanner.Err()
+}
+
+// socketOwners maps socket inodes to PIDs through the socket:[INODE] links in /proc/PID/fd
+func (fs procFS) socketOwners() (map[uint64]int, error) {
+	pids, err := fs.pids()
+	if err != nil {
+		return nil, err
+	}
+	owners := make(map[uint64]int)
+	for _, pid := range pids {
+		fds, err := ioutil.ReadDir(fs.path(strconv.Itoa(pid), "fd"))
+		if err != nil {
+			// gone, or not ours
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(fs.path(strconv.Itoa(pid), "fd", fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			inode, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]"), 10, 64)
+			if err != nil {
+				continue
+			}
+			// a socket shared after fork belongs to the oldest process, the lowest PID most of the time
+			if _, ok := owners[inode]; !ok {
+				owners[inode] = pid
+			}
+		}
+	}
+	return owners, nil
+}
+
+// sockets lists the TCP and UDP sockets, IPv4 and IPv6, with the PID of their owner
+func (fs procFS) sockets() ([]socket, error) {
+	var sockets []socket
+	for _, proto := range socketProtos {
+		s, err := fs.readSockets(proto)
+		if os.IsNotExist(err) {
+			// no IPv6 in the kernel
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sockets = append(sockets, s...)
+	}
+	owners, err := fs.socketOwners()
+	if err != nil {
+		return nil, err
+	}
+	for i := range sockets {
+		sockets[i].PID = owners[sockets[i].Inode]
+	}
+	return sockets, nil
+}
+
+func (fs procFS) listeners(keep func(socket) bool) ([]socket, error) {
+	sockets, err := fs.sockets()
+	if err != nil {
+		return nil, err
+	}
+	var found []socket
+	for _, s := range sockets {
+		if s.listening() && keep(s) {
+			found = append(found, s)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].LocalPort != found[j].LocalPort {
+			return found[i].LocalPort < found[j].LocalPort
+		}
+		return found[i].Proto < found[j].Proto
+	})
+	return found, nil
+}
+
+// portOwners answers "which process owns port N": the sockets listening on the port, TCP and UDP
+func (fs procFS) portOwners(port int) ([]socket, error) {
+	return fs.listeners(func(s socket) bool { return s.LocalPort == port })
+}
+
+// listeningPorts answers "which ports does process P listen on"
+func (fs procFS) listeningPorts(pid int) ([]socket, error) {
+	return fs.listeners(func(s socket) bool { return s.PID == pid })
+}
+
+// portsOf lists the ports every process named proc_name (as for pidof) listens on
+func (fs procFS) portsOf(proc_name string) ([]socket, error) {
+	procs, err := fs.find(processQuery{Name: proc_name})
+	if err != nil {
+		return nil, err
+	}
+	pids := make(map[int]bool)
+	for _, p := range procs {
+		pids[p.PID] = true
+	}
+	return fs.listeners(func(s socket) bool { return pids[s.PID] })
+}
+
+func runPorts(args []string) int {
+	fs := flag.NewFlagSet("ports", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: utils ports [flags]\n\nwithout flags, every listening socket\n\nflags:\n")
+		fs.PrintDefaults()
+	}
+	port := fs.Int("port", 0, "which process listens on this port")
+	pid := fs.Int("pid", 0, "which ports this process listens on")
+	name := fs.String("process", "", "which ports the processes with this name listen on")
+	asJSON := fs.Bool("json", false, "print JSON")
+	fs.Parse(args)
+
+	var sockets []socket
+	var err error
+	switch {
+	case *port > 0:
+		sockets, err = defaultProcFS.portOwners(*port)
+	case *pid > 0:
+		sockets, err = defaultProcFS.listeningPorts(*pid)
+	case *name != "":
+		sockets, err = defaultProcFS.portsOf(*name)
+	default:
+		sockets, err = defaultProcFS.listeners(func(socket) bool { return true })
+	}
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(sockets)
+	} else {
+		for _, s := range sockets {
+			owner := "?"
+			if s.PID > 0 {
+				owner = strconv.Itoa(s.PID)
+				if p, err := defaultProcFS.process(s.PID); err == nil {
+					owner += " " + p.Comm
+				}
+			}
+			fmt.Printf("%-4s %s %s\n", s.Proto, net.JoinHostPort(s.LocalIP.String(), strconv.Itoa(s.LocalPort)), owner)
+		}
+	}
+	// like pidof, nothing found is a failure
+	if len(sockets) == 0 {
+		return 1
+	}
+	return 0
+}
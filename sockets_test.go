@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const socketsHeader = "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+
+// fakeSockets adds /proc/net tables to fakeProc: systemd (1) listens on [::1]:22 and UDP 53,
+// python (42) on 127.0.0.1:8080 with a connection, and nobody visible on 9090
+func fakeSockets(t *testing.T) procFS {
+	fs := fakeProc(t)
+	tables := map[string]string{
+		"tcp": socketsHeader +
+			"   0: 0100007F:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000 64999        0 1001 1 0000000000000000 100 0 0 10 0\n" +
+			"   1: 0100007F:1F90 0100007F:C350 01 00000000:00000000 00:00000000 00000000 64999        0 1004 1 0000000000000000 20 4 30 10 -1\n" +
+			"   2: 00000000:2382 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1005 1 0000000000000000 100 0 0 10 0\n",
+		"tcp6": socketsHeader +
+			"   0: 00000000000000000000000001000000:0016 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1002 1 0000000000000000 100 0 0 10 0\n",
+		"udp": socketsHeader +
+			"  12: 00000000:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 1003 2 0000000000000000 0\n",
+	}
+	if err := os.MkdirAll(filepath.Join(fs.root, "net"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range tables {
+		if err := ioutil.WriteFile(filepath.Join(fs.root, "net", name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"42/fd/3": "socket:[1001]",
+		"42/fd/4": "socket:[1004]",
+		"42/fd/5": "/dev/null",
+		"1/fd/5":  "socket:[1002]",
+		"1/fd/6":  "socket:[1003]",
+	}
+	for name, target := range links {
+		path := filepath.Join(fs.root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fs
+}
+
+func TestParseSocketAddress(t *testing.T) {
+	tests := []struct {
+		addr string
+		ip   string
+		port int
+	}{
+		{"0100007F:1F90", "127.0.0.1", 8080},
+		{"00000000:0035", "0.0.0.0", 53},
+		{"00000000000000000000000001000000:0016", "::1", 22},
+		{"B80D0120000000000000000001000000:01BB", "2001:db8::1", 443},
+	}
+	for _, test := range tests {
+		ip, port, err := parseSocketAddress(test.addr)
+		if err != nil || !ip.Equal(net.ParseIP(test.ip)) || port != test.port {
+			t.Errorf("%s: got %v %d %v, want %s %d", test.addr, ip, port, err, test.ip, test.port)
+		}
+	}
+	for _, addr := range []string{"", "0100007F", "0100007:1F90", "0100007F:XYZ"} {
+		if _, _, err := parseSocketAddress(addr); err == nil {
+			t.Errorf("%q accepted", addr)
+		}
+	}
+}
+
+func TestSockets(t *testing.T) {
+	fs := fakeSockets(t)
+	sockets, err := fs.sockets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sockets) != 5 {
+		t.Fatalf("got %d sockets, want 5", len(sockets))
+	}
+	established := sockets[1]
+	if established.State != "ESTABLISHED" || established.RemotePort != 50000 || established.PID != 42 || established.UID != 64999 || established.listening() {
+		t.Errorf("established: %+v", established)
+	}
+
+	owners, err := fs.portOwners(8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(owners) != 1 || owners[0].PID != 42 || !owners[0].LocalIP.Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("port 8080: %+v", owners)
+	}
+	// the owner of 9090 is not visible
+	if owners, _ := fs.portOwners(9090); len(owners) != 1 || owners[0].PID != 0 {
+		t.Errorf("port 9090: %+v", owners)
+	}
+
+	ports, err := fs.listeningPorts(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ports) != 2 || ports[0].Proto != "tcp6" || ports[0].LocalPort != 22 || ports[1].Proto != "udp" || ports[1].LocalPort != 53 {
+		t.Errorf("ports of 1: %+v", ports)
+	}
+
+	ports, err = fs.portsOf("python3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ports) != 1 || ports[0].LocalPort != 8080 {
+		t.Errorf("ports of python3: %+v", ports)
+	}
+	if ports, _ := fs.portsOf("nginx"); len(ports) != 0 {
+		t.Errorf("ports of nginx: %+v", ports)
+	}
+}
+
+func TestListeningPorts(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	owners, err := defaultProcFS.portOwners(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(owners) != 1 || owners[0].PID != os.Getpid() {
+		t.Errorf("port %d: %+v", port, owners)
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+	supervise: starts a list of commands and keeps them running. A command is
+	restarted, with exponential backoff, when it exits or when its port (if any)
+	stops accepting connections. Standard output and error go to NAME.log in the
+	log directory, rotated by size. On SIGINT or SIGTERM every command gets
+	SIGTERM, then SIGKILL if it is still there after stop_timeout. Whatever a
+	command leaves behind in its process group, when it exits or is stopped, gets
+	the same treatment before the restart.
+*/
+
+type programConfig struct {
+	Name    string   `yaml:"name" toml:"name"`
+	Command []string `yaml:"command" toml:"command"` // the program and its arguments
+	Dir     string   `yaml:"dir" toml:"dir"`
+	Host    string   `yaml:"host" toml:"host"` // of the port, 127.0.0.1 when empty
+	Port    int      `yaml:"port" toml:"port"` // checked every check_interval when not zero
+}
+
+type superviseConfig struct {
+	LogDir        string          `yaml:"log_dir" toml:"log_dir"`
+	LogSizeMB     int64           `yaml:"log_size_mb" toml:"log_size_mb"` // rotate past this size
+	LogKeep       int             `yaml:"log_keep" toml:"log_keep"`       // rotated files to keep, NAME.log.1 the newest
+	StopTimeout   duration        `yaml:"stop_timeout" toml:"stop_timeout"`
+	CheckInterval duration        `yaml:"check_interval" toml:"check_interval"`
+	StartGrace    duration        `yaml:"start_grace" toml:"start_grace"` // before the first port check
+	Fall          int             `yaml:"fall" toml:"fall"`               // failed port checks in a row before a restart
+	MinBackoff    duration        `yaml:"min_backoff" toml:"min_backoff"`
+	MaxBackoff    duration        `yaml:"max_backoff" toml:"max_backoff"`
+	Stable        duration        `yaml:"stable" toml:"stable"` // running this long resets the backoff
+	Programs      []programConfig `yaml:"programs" toml:"programs"`
+}
+
+// loadSuperviseConfig reads a .toml file, or YAML for any other extension
+func loadSuperviseConfig(path string) (*superviseConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &superviseConfig{
+		LogDir:        ".",
+		LogSizeMB:     10,
+		LogKeep:       5,
+		StopTimeout:   duration{10 * time.Second},
+		CheckInterval: duration{5 * time.Second},
+		StartGrace:    duration{10 * time.Second},
+		Fall:          3,
+		MinBackoff:    duration{time.Second},
+		MaxBackoff:    duration{time.Minute},
+		Stable:        duration{30 * time.Second},
+	}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		err = toml.Unmarshal(content, config)
+	} else {
+		err = yaml.Unmarshal(content, config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if config.CheckInterval.Duration <= 0 || config.MinBackoff.Duration <= 0 || config.MaxBackoff.Duration < config.MinBackoff.Duration {
+		return nil, fmt.Errorf("%s: check_interval and min_backoff must be positive, max_backoff at least min_backoff", path)
+	}
+	if config.StopTimeout.Duration <= 0 {
+		return nil, fmt.Errorf("%s: stop_timeout must be positive", path)
+	}
+	if config.LogSizeMB < 1 || config.Fall < 1 {
+		return nil, fmt.Errorf("%s: log_size_mb and fall must be at least 1", path)
+	}
+	names := make(map[string]bool)
+	for _, p := range config.Programs {
+		if p.Name == "" || names[p.Name] || strings.ContainsRune(p.Name, os.PathSeparator) {
+			return nil, fmt.Errorf("%s: every program needs a unique name, usable as a file name", path)
+		}
+		names[p.Name] = true
+		if len(p.Command) == 0 {
+			return nil, fmt.Errorf("%s: program %s has no command", path, p.Name)
+		}
+	}
+	return config, nil
+}
+
+// rotatingWriter appends to a file, renamed to path.1 (path.1 to path.2...) when it grows past maxSize
+type rotatingWriter struct {
+	path    string
+	maxSize int64
+	keep    int
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+}
+
+func openRotatingWriter(path string, maxSize int64, keep int) (*rotatingWriter, error) {
+	w := &rotatingWriter{path: path, maxSize: maxSize, keep: keep}
+	return w, w.open()
+}
+
+func (w *rotatingWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file, w.size = f, info.Size()
+	return nil
+}
+
+func (w *rotatingWriter) rotate() error {
+	w.file.Close()
+	if w.keep < 1 {
+		os.Remove(w.path)
+	} else {
+		for n := w.keep - 1; n > 0; n-- {
+			os.Rename(w.path+"."+strconv.Itoa(n), w.path+"."+strconv.Itoa(n+1))
+		}
+		if err := os.Rename(w.path, w.path+".1"); err != nil {
+			return err
+		}
+	}
+	return w.open()
+}
+
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *rotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
+
+type supervised struct {
+	program programConfig
+	config  *superviseConfig
+	output  *rotatingWriter
+}
+
+// stop sends SIGTERM to the process group, then SIGKILL after the stop timeout, and waits for the exit
+func (s *supervised) stop(pid int, exited <-chan error) {
+	syscall.Kill(-pid, syscall.SIGTERM)
+	select {
+	case <-exited:
+		return
+	case <-time.After(s.config.StopTimeout.Duration):
+	}
+	log.Printf("%s: still running after %s, killing", s.program.Name, s.config.StopTimeout)
+	syscall.Kill(-pid, syscall.SIGKILL)
+	<-exited
+}
+
+/*
+killGroup ends what is left in the process group once the leader is gone:
+children that outlive it would keep its port, and the pipe of its output.
+*/
+func (s *supervised) killGroup(pgid int) {
+	if err := syscall.Kill(-pgid, syscall.SIGTERM); err != nil {
+		// nobody left
+		return
+	}
+	deadline := time.Now().Add(s.config.StopTimeout.Duration)
+	for time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+		if err := syscall.Kill(-pgid, 0); err != nil {
+			return
+		}
+	}
+	log.Printf("%s: children still running after %s, killing", s.program.Name, s.config.StopTimeout)
+	syscall.Kill(-pgid, syscall.SIGKILL)
+}
+
+/*
+monitor waits for the process to exit, or for its port to fail fall checks in a
+row (then it stops the process), or for the context to be done. It returns why
+the process is no longer running, empty when the context is done.
+*/
+func (s *supervised) monitor(ctx context.Context, pid int, exited <-chan error) string {
+	var checks <-chan time.Time
+	if s.program.Port != 0 {
+		ticker := time.NewTicker(s.config.CheckInterval.Duration)
+		defer ticker.Stop()
+		checks = ticker.C
+	}
+	host := s.program.Host
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	started := time.Now()
+	failures := 0
+	for {
+		select {
+		case err := <-exited:
+			if err == nil {
+				return "exited"
+			}
+			return err.Error()
+		case <-ctx.Done():
+			s.stop(pid, exited)
+			return ""
+		case <-checks:
+			if time.Since(started) < s.config.StartGrace.Duration {
+				continue
+			}
+			r, conn := dialPort(ctx, host, s.program.Port, s.config.CheckInterval.Duration)
+			if conn != nil {
+				conn.Close()
+				failures = 0
+				continue
+			}
+			if failures++; failures < s.config.Fall {
+				continue
+			}
+			log.Printf("%s: port %d not answering (%s), stopping", s.program.Name, s.program.Port, r.Error)
+			s.stop(pid, exited)
+			return "port not answering"
+		}
+	}
+}
+
+// run keeps the program running until the context is done
+func (s *supervised) run(ctx context.Context) {
+	backoff := s.config.MinBackoff.Duration
+	for {
+		cmd := exec.Command(s.program.Command[0], s.program.Command[1:]...)
+		cmd.Dir = s.program.Dir
+		cmd.Stdout, cmd.Stderr = s.output, s.output
+		// its own process group, so that stop reaches the children too
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		// a child still holding the output pipe would block Wait after the exit
+		cmd.WaitDelay = time.Second
+		started := time.Now()
+		if err := cmd.Start(); err != nil {
+			log.Printf("%s: %v", s.program.Name, err)
+		} else {
+			log.Printf("%s: started, pid %d", s.program.Name, cmd.Process.Pid)
+			exited := make(chan error, 1)
+			go func() { exited <- cmd.Wait() }()
+			reason := s.monitor(ctx, cmd.Process.Pid, exited)
+			s.killGroup(cmd.Process.Pid)
+			if reason == "" {
+				log.Printf("%s: stopped", s.program.Name)
+				return
+			}
+			log.Printf("%s: %s after %s", s.program.Name, reason, time.Since(started).Round(time.Millisecond))
+		}
+
+		if time.Since(started) >= s.config.Stable.Duration {
+			backoff = s.config.MinBackoff.Duration
+		}
+		log.Printf("%s: restarting in %s", s.program.Name, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		if backoff *= 2; backoff > s.config.MaxBackoff.Duration {
+			backoff = s.config.MaxBackoff.Duration
+		}
+	}
+}
+
+func runSupervise(args []string) int {
+	fs := flag.NewFlagSet("supervise", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: utils supervise [flags]\n\nflags:\n")
+		fs.PrintDefaults()
+	}
+	configPath := fs.String("config", "supervise.yaml", "configuration, YAML or TOML (.toml)")
+	fs.Parse(args)
+
+	config, err := loadSuperviseConfig(*configPath)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	if len(config.Programs) == 0 {
+		log.Printf("%s: nothing to supervise", *configPath)
+		return 1
+	}
+	if err := os.MkdirAll(config.LogDir, 0755); err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	failed := false
+	var wg sync.WaitGroup
+	for _, p := range config.Programs {
+		output, err := openRotatingWriter(filepath.Join(config.LogDir, p.Name+".log"), config.LogSizeMB<<20, config.LogKeep)
+		if err != nil {
+			log.Println(err)
+			failed = true
+			cancel()
+			break
+		}
+		defer output.Close()
+		s := &supervised{program: p, config: config, output: output}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.run(ctx)
+		}()
+	}
+	wg.Wait()
+	if failed {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLoadSuperviseConfig(t *testing.T) {
+	dir := t.TempDir()
+	load := func(name, content string) (*superviseConfig, error) {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return loadSuperviseConfig(path)
+	}
+
+	config, err := load("ok.yaml", "stop_timeout: 3s\nprograms:\n  - name: api\n    command: [./api]\n    port: 8080\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.StopTimeout.Duration != 3*time.Second || config.Fall != 3 || len(config.Programs) != 1 || config.Programs[0].Port != 8080 {
+		t.Errorf("got %+v", config)
+	}
+	config, err = load("ok.toml", "log_keep = 2\n[[programs]]\nname = \"worker\"\ncommand = [\"./worker\"]\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.LogKeep != 2 || config.Programs[0].Name != "worker" {
+		t.Errorf("got %+v", config)
+	}
+
+	for name, content := range map[string]string{
+		"zero-stop.yaml":     "stop_timeout: 0s\n",
+		"negative-stop.yaml": "stop_timeout: -1s\n",
+		"backoff.yaml":       "min_backoff: 10s\nmax_backoff: 1s\n",
+		"fall.yaml":          "fall: 0\n",
+		"duplicate.yaml":     "programs:\n  - {name: a, command: [x]}\n  - {name: a, command: [y]}\n",
+		"path.yaml":          "programs:\n  - {name: a/b, command: [x]}\n",
+		"command.yaml":       "programs:\n  - {name: a}\n",
+	} {
+		if _, err := load(name, content); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
+
+func TestRotatingWriter(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	w, err := openRotatingWriter(path, 20, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := w.Write([]byte("line " + strconv.Itoa(i) + " of log\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("%d files, want app.log and 2 rotated", len(files))
+	}
+	newest, err := ioutil.ReadFile(path + ".1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(current), "line 4") || !strings.Contains(string(newest), "line 3") {
+		t.Errorf("app.log %q, app.log.1 %q", current, newest)
+	}
+}
+
+func TestSuperviseKillsLeftovers(t *testing.T) {
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "child.pid")
+	output, err := openRotatingWriter(filepath.Join(dir, "leaver.log"), 1<<20, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+	// the leader leaves a child behind, holding the output pipe
+	s := &supervised{
+		program: programConfig{Name: "leaver", Command: []string{"sh", "-c", "sleep 60 & echo $! > " + pidFile + "; exit 0"}},
+		config: &superviseConfig{
+			StopTimeout: duration{time.Second},
+			MinBackoff:  duration{time.Hour},
+			MaxBackoff:  duration{time.Hour},
+		},
+		output: output,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() {
+		s.run(ctx)
+		close(done)
+	}()
+
+	var child int
+	for deadline := time.Now().Add(5 * time.Second); child == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("the program didn't start")
+		}
+		time.Sleep(20 * time.Millisecond)
+		content, _ := ioutil.ReadFile(pidFile)
+		child, _ = strconv.Atoi(strings.TrimSpace(string(content)))
+	}
+	// the restart waits for the backoff, the child must be gone before
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		p, err := defaultProcFS.process(child)
+		if os.IsNotExist(err) || (err == nil && p.State == "Z") {
+			break
+		}
+		if time.Now().After(deadline) {
+			syscall.Kill(child, syscall.SIGKILL)
+			t.Fatal("the child of the exited program is still running")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run didn't return")
+	}
+}
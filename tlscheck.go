@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type tlsOptions struct {
+	Timeout    time.Duration  // of the dial and handshake, 5 seconds when zero
+	ServerName string         // for SNI and the hostname verification, the host when empty
+	RootCAs    *x509.CertPool // the system roots when nil
+	WarnDays   int            // warn when a certificate of the chain expires within this many days, 0 for no warning
+}
+
+type tlsReport struct {
+	Host        string        `json:"host"`
+	Port        int           `json:"port"`
+	Version     string        `json:"version"` // TLS 1.2, TLS 1.3...
+	Cipher      string        `json:"cipher"`
+	Chain       []certInfo    `json:"chain"`     // as sent by the server, the leaf first
+	DaysLeft    int           `json:"days_left"` // until the first certificate of the chain expires, negative when expired
+	ChainValid  bool          `json:"chain_valid"`
+	HostnameOK  bool          `json:"hostname_ok"`
+	VerifyError string        `json:"verify_error,omitempty"`
+	Warning     string        `json:"warning,omitempty"` // expiring within WarnDays, or expired
+	Latency     time.Duration `json:"latency"`           // connection and handshake
+}
+
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+/*
+checkTLS dials host:port like checkOpenPort, then completes a TLS handshake
+and reports what was negotiated and the certificates. The handshake accepts any
+certificate, so that an invalid one can still be inspected: the chain and the
+hostname are verified afterwards, separately, and the result is in the report.
+The error is only for a failed connection or handshake.
+*/
+func checkTLS(ctx context.Context, host string, port int, opts tlsOptions) (tlsReport, error) {
+	r := tlsReport{Host: host, Port: port}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	serverName := opts.ServerName
+	if serverName == "" {
+		serverName = host
+	}
+	config := &tls.Config{InsecureSkipVerify: true}
+	if net.ParseIP(serverName) == nil {
+		config.ServerName = serverName
+	}
+	dialer := &tls.Dialer{NetDialer: &net.Dialer{Timeout: timeout}, Config: config}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	r.Latency = time.Since(start)
+	if err != nil {
+		return r, err
+	}
+	defer conn.Close()
+	state := conn.(*tls.Conn).ConnectionState()
+
+	r.Version = tlsVersions[state.Version]
+	if r.Version == "" {
+		r.Version = fmt.Sprintf("0x%04x", state.Version)
+	}
+	r.Cipher = tls.CipherSuiteName(state.CipherSuite)
+	if len(state.PeerCertificates) == 0 {
+		r.VerifyError = "no certificate"
+		return r, nil
+	}
+
+	now := time.Now()
+	first := state.PeerCertificates[0].NotAfter
+	for _, cert := range state.PeerCertificates {
+		r.Chain = append(r.Chain, newCertInfo(cert))
+		if cert.NotAfter.Before(first) {
+			first = cert.NotAfter
+		}
+	}
+	// rounded down, so that half a day after the expiry is -1
+	r.DaysLeft = int(math.Floor(first.Sub(now).Hours() / 24))
+	if first.Before(now) {
+		r.Warning = fmt.Sprintf("certificate expired on %s", first.Format("2006-01-02"))
+	} else if opts.WarnDays > 0 && r.DaysLeft < opts.WarnDays {
+		r.Warning = fmt.Sprintf("certificate expires in %d days, on %s", r.DaysLeft, first.Format("2006-01-02"))
+	}
+
+	leaf := state.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{Roots: opts.RootCAs, Intermediates: intermediates, CurrentTime: now}); err != nil {
+		r.VerifyError = err.Error()
+	} else {
+		r.ChainValid = true
+	}
+	if err := leaf.VerifyHostname(serverName); err != nil {
+		if r.VerifyError == "" {
+			r.VerifyError = err.Error()
+		}
+	} else {
+		r.HostnameOK = true
+	}
+	return r, nil
+}
+
+// parseTLSTarget reads host:port, the port is 443 when missing and can be a service name
+func parseTLSTarget(target string) (string, int, error) {
+	host, service, err := net.SplitHostPort(target)
+	if err != nil {
+		host, service = strings.Trim(target, "[]"), "443"
+	}
+	port, err := net.LookupPort("tcp", service)
+	if err != nil || host == "" {
+		return "", 0, fmt.Errorf("invalid target %q, expected host:port", target)
+	}
+	return host, port, nil
+}
+
+func runTLS(args []string) int {
+	fs := flag.NewFlagSet("tls", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: utils tls [flags] host[:port]...\n\nflags:\n")
+		fs.PrintDefaults()
+	}
+	var opts tlsOptions
+	fs.DurationVar(&opts.Timeout, "timeout", 5*time.Second, "of the connection and handshake")
+	fs.StringVar(&opts.ServerName, "servername", "", "name for SNI and the hostname check, the host when empty")
+	fs.IntVar(&opts.WarnDays, "warn-days", 30, "warn when a certificate expires within this many days, 0 for no warning")
+	caFile := fs.String("cafile", "", "PEM file with the trusted roots, instead of the system ones")
+	asJSON := fs.Bool("json", false, "print JSON")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if *caFile != "" {
+		pem, err := ioutil.ReadFile(*caFile)
+		if err != nil {
+			log.Println(err)
+			return 2
+		}
+		opts.RootCAs = x509.NewCertPool()
+		if !opts.RootCAs.AppendCertsFromPEM(pem) {
+			log.Printf("%s: no certificates", *caFile)
+			return 2
+		}
+	}
+
+	// a failed handshake, an invalid certificate or a warning is a failure
+	failed := false
+	var reports []tlsReport
+	for _, target := range fs.Args() {
+		host, port, err := parseTLSTarget(target)
+		if err != nil {
+			log.Println(err)
+			return 2
+		}
+		r, err := checkTLS(context.Background(), host, port, opts)
+		if err != nil {
+			log.Printf("%s: %v", target, err)
+			failed = true
+			continue
+		}
+		failed = failed || !r.ChainValid || !r.HostnameOK || r.Warning != ""
+		reports = append(reports, r)
+		if *asJSON {
+			continue
+		}
+		fmt.Printf("%s: %s %s, %d days left\n", net.JoinHostPort(host, strconv.Itoa(port)), r.Version, r.Cipher, r.DaysLeft)
+		for i, cert := range r.Chain {
+			fmt.Printf("  %d %s (issuer %s, until %s)\n", i, cert.Subject, cert.Issuer, cert.NotAfter.Format("2006-01-02"))
+		}
+		if r.VerifyError != "" {
+			fmt.Printf("  invalid: %s\n", r.VerifyError)
+		}
+		if r.Warning != "" {
+			fmt.Printf("  warning: %s\n", r.Warning)
+		}
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(reports)
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// issue creates a certificate for name, signed by parent (self signed when nil)
+func issue(t *testing.T, name string, notAfter time.Time, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if !isCA {
+		template.DNSNames = []string{name}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		template.EmailAddresses = []string{"admin@" + name}
+		template.URIs = []*url.URL{{Scheme: "spiffe", Host: name, Path: "/server"}}
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// tlsServer serves the leaf and the chain after it, and returns the port
+func tlsServer(t *testing.T, key *ecdsa.PrivateKey, chain ...*x509.Certificate) int {
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	var certificate tls.Certificate
+	for _, cert := range chain {
+		certificate.Certificate = append(certificate.Certificate, cert.Raw)
+	}
+	certificate.PrivateKey = key
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{certificate}}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server.Listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestCheckTLS(t *testing.T) {
+	now := time.Now()
+	ca, caKey := issue(t, "Test CA", now.AddDate(5, 0, 0), true, nil, nil)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	leaf, leafKey := issue(t, "localhost", now.AddDate(0, 0, 10).Add(time.Hour), false, ca, caKey)
+	expiring := tlsServer(t, leafKey, leaf, ca)
+	old, oldKey := issue(t, "localhost", now.Add(-36*time.Hour), false, ca, caKey)
+	expired := tlsServer(t, oldKey, old, ca)
+	self, selfKey := issue(t, "localhost", now.AddDate(1, 0, 0), false, nil, nil)
+	selfSigned := tlsServer(t, selfKey, self)
+
+	ctx := context.Background()
+	r, err := checkTLS(ctx, "localhost", expiring, tlsOptions{RootCAs: roots, WarnDays: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Chain) != 2 || r.Chain[0].Subject != "CN=localhost" || r.Chain[0].Issuer != "CN=Test CA" || r.Chain[1].Subject != "CN=Test CA" {
+		t.Fatalf("chain %+v", r.Chain)
+	}
+	names := r.Chain[0]
+	if len(names.DNSNames) != 1 || len(names.IPAddresses) != 1 || names.IPAddresses[0] != "127.0.0.1" ||
+		len(names.EmailAddresses) != 1 || names.EmailAddresses[0] != "admin@localhost" ||
+		len(names.URIs) != 1 || names.URIs[0] != "spiffe://localhost/server" {
+		t.Errorf("subject alternative names %+v", names)
+	}
+	if !r.ChainValid || !r.HostnameOK || r.VerifyError != "" || r.Version == "" || r.Cipher == "" {
+		t.Errorf("valid certificate: %+v", r)
+	}
+	if r.DaysLeft != 10 || r.Warning == "" {
+		t.Errorf("expiring: %d days left, warning %q", r.DaysLeft, r.Warning)
+	}
+	if r, _ := checkTLS(ctx, "localhost", expiring, tlsOptions{RootCAs: roots, WarnDays: 5}); r.Warning != "" {
+		t.Errorf("warning %q, with 10 days left and 5 to warn", r.Warning)
+	}
+
+	// the hostname mismatch doesn't invalidate the chain
+	r, err = checkTLS(ctx, "localhost", expiring, tlsOptions{RootCAs: roots, ServerName: "example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.ChainValid || r.HostnameOK || r.VerifyError == "" {
+		t.Errorf("hostname mismatch: %+v", r)
+	}
+
+	r, err = checkTLS(ctx, "127.0.0.1", expired, tlsOptions{RootCAs: roots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ChainValid || !r.HostnameOK || r.DaysLeft != -2 || r.Warning == "" {
+		t.Errorf("expired: %+v", r)
+	}
+
+	// unknown authority, still inspected
+	r, err = checkTLS(ctx, "localhost", selfSigned, tlsOptions{RootCAs: roots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ChainValid || r.VerifyError == "" || len(r.Chain) != 1 {
+		t.Errorf("self signed: %+v", r)
+	}
+
+	if _, err := checkTLS(ctx, "127.0.0.1", closedPort(t), tlsOptions{Timeout: time.Second}); err == nil {
+		t.Error("no error from a closed port")
+	}
+}
+
+func TestParseTLSTarget(t *testing.T) {
+	tests := []struct {
+		target string
+		host   string
+		port   int
+	}{
+		{"example.org", "example.org", 443},
+		{"example.org:8443", "example.org", 8443},
+		{"mail.example.org:imaps", "mail.example.org", 993},
+		{"[::1]:443", "::1", 443},
+		{"::1", "::1", 443},
+	}
+	for _, test := range tests {
+		host, port, err := parseTLSTarget(test.target)
+		if err != nil || host != test.host || port != test.port {
+			t.Errorf("%s: got %s %d %v", test.target, host, port, err)
+		}
+	}
+	for _, target := range []string{"", ":443", "host:nosuchservice"} {
+		if _, _, err := parseTLSTarget(target); err == nil {
+			t.Errorf("%q accepted", target)
+		}
+	}
+}
@@ -2,85 +2,28 @@ package main
 
 import (
 	"errors"
-	"io/ioutil"
 	"net"
-	"os"
-	"strconv"
-	"strings"
 	"time"
 )
 
+// pidof returns the first process named proc_name (comm, argv[0] or executable), see procFS.find for all of them
 func pidof(proc_name string) (int, error) {
-	const proc_dir string = "/proc"
-	if os.Chdir(proc_dir) != nil {
-		return -1, errors.New("/proc unavailable.")
-	}
-
-	files, err := ioutil.ReadDir(".")
+	procs, err := defaultProcFS.find(processQuery{Name: proc_name})
 	if err != nil {
-		return -1, errors.New("unable to read /proc directory.")
+		return -1, err
 	}
-
-	for _, file := range files {
-		// Ignore files, we only care about directories
-		if file.IsDir() {
-			// Our directory name should convert to integer
-			// if it's a PID
-			pid, err := strconv.Atoi(file.Name())
-			if err != nil {
-				continue
-			}
-
-			/*
-				From https://github.com/brgl/busybox/blob/master/libbb/find_pid_by_name.c:
-
-					In Linux we have three ways to determine "process name":
-					1. /proc/PID/stat has "...(name)...", among other things. It's so-called "comm" field.
-					2. /proc/PID/cmdline's first NUL-terminated string. It's argv[0] from exec syscall.
-					3. /proc/PID/exe symlink. Points to the running executable file.
-					kernel threads:
-						comm: thread name
-						cmdline: empty
-						exe: <readlink fails>
-					executable
-						comm: first 15 chars of base name
-						(if executable is a symlink, then first 15 chars of symlink name are used)
-						cmdline: argv[0] from exec syscall
-						exe: points to executable (resolves symlink, unlike comm)
-					script (an executable with #!/path/to/interpreter):
-						comm: first 15 chars of script's base name (symlinks are not resolved)
-						cmdline: /path/to/interpreter (symlinks are not resolved)
-						(script name is in argv[1], args are pushed into argv[2] etc)
-						exe: points to interpreter's executable (symlinks are resolved)
-						If FEATURE_PREFER_APPLETS=y (and more so if FEATURE_SH_STANDALONE=y),
-						some commands started from busybox shell, xargs or find are started by
-						execXXX("/proc/self/exe", applet_name, params....)
-						and therefore comm field contains "exe".
-
-				Therefore we parse the resolved exe symlink, this should cover most of our needs...
-
-			*/
-			exe, err := os.Readlink(file.Name() + "/exe")
-			if err != nil {
-				continue
-			}
-			if strings.Contains(exe, proc_name) {
-				return pid, nil
-			}
-		}
+	if len(procs) == 0 {
+		return -1, errors.New("pid not found")
 	}
-	return -1, errors.New("pid not found")
+	return procs[0].PID, nil
 }
 
+// checkOpenPort dials host:port (a number or a service name) with a 1 second timeout, see portScanner for many ports at once
 func checkOpenPort(host string, port string) (bool, error) {
-	timeout := time.Second
-	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), timeout)
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), time.Second)
 	if err != nil {
 		return false, err
 	}
-	if conn != nil {
-		defer conn.Close()
-		return true, nil
-	}
-	return false, errors.New("Unknow error")
+	conn.Close()
+	return true, nil
 }
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// waitBackoff is how often the wait functions check, zero values take the defaults
+type waitBackoff struct {
+	Initial time.Duration // first delay, 100ms when zero
+	Max     time.Duration // delays double up to this, 5 seconds when zero
+	// Progress is called after every failed attempt, with the reason
+	Progress func(attempt int, elapsed time.Duration, err error)
+}
+
+/*
+waitFor calls check until it succeeds or the context is done, sleeping between
+the attempts with exponential backoff. On timeout or cancellation the error
+wraps the context error and tells the reason of the last failure.
+*/
+func waitFor(ctx context.Context, b waitBackoff, check func(context.Context) error) error {
+	delay := b.Initial
+	if delay <= 0 {
+		delay = 100 * time.Millisecond
+	}
+	max := b.Max
+	if max <= 0 {
+		max = 5 * time.Second
+	}
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := check(ctx)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w: %v", ctx.Err(), err)
+		}
+		if b.Progress != nil {
+			b.Progress(attempt, time.Since(start), err)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w: %v", ctx.Err(), err)
+		}
+		if delay *= 2; delay > max {
+			delay = max
+		}
+	}
+}
+
+// WaitOption changes the defaults of the Wait functions
+type WaitOption func(*waitBackoff)
+
+// WithBackoff sets the first delay and the maximum one, zero keeps the default
+func WithBackoff(initial, max time.Duration) WaitOption {
+	return func(b *waitBackoff) {
+		b.Initial, b.Max = initial, max
+	}
+}
+
+// WithProgress calls progress after every failed attempt, with the reason
+func WithProgress(progress func(attempt int, elapsed time.Duration, err error)) WaitOption {
+	return func(b *waitBackoff) {
+		b.Progress = progress
+	}
+}
+
+func newWaitBackoff(opts []WaitOption) waitBackoff {
+	var b waitBackoff
+	for _, opt := range opts {
+		opt(&b)
+	}
+	return b
+}
+
+// WaitForPort returns when host:port accepts connections
+func WaitForPort(ctx context.Context, host string, port int, opts ...WaitOption) error {
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	return waitFor(ctx, newWaitBackoff(opts), func(ctx context.Context) error {
+		dialer := net.Dialer{Timeout: time.Second}
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		conn.Close()
+		return nil
+	})
+}
+
+// WaitForReach returns when the check succeeds: TCP or Unix connects, UDP gets an answer
+func WaitForReach(ctx context.Context, c reachCheck, opts ...WaitOption) error {
+	return waitFor(ctx, newWaitBackoff(opts), func(ctx context.Context) error {
+		r := c.check(ctx)
+		if r.Status != reachSuccess {
+			return fmt.Errorf("%s: %s", r.Status, r.Error)
+		}
+		return nil
+	})
+}
+
+// WaitForProcess returns the PID of the first process named name (as for pidof), once there is one
+func WaitForProcess(ctx context.Context, name string, opts ...WaitOption) (int, error) {
+	pid := -1
+	err := waitFor(ctx, newWaitBackoff(opts), func(ctx context.Context) error {
+		procs, err := defaultProcFS.find(processQuery{Name: name})
+		if err != nil {
+			return err
+		}
+		if len(procs) == 0 {
+			return fmt.Errorf("no process named %s", name)
+		}
+		pid = procs[0].PID
+		return nil
+	})
+	return pid, err
+}
+
+// WaitForExit returns when the process is gone, or a zombie waiting for its parent
+func WaitForExit(ctx context.Context, pid int, opts ...WaitOption) error {
+	return waitFor(ctx, newWaitBackoff(opts), func(ctx context.Context) error {
+		p, err := defaultProcFS.process(pid)
+		if os.IsNotExist(err) || (err == nil && (p.State == "Z" || p.State == "X")) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%d is still running (%s)", pid, p.Comm)
+	})
+}
+
+// stringList is a flag that can be repeated
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+/*
+runWait is the wait-for-it of the utils binary: it waits for every condition at
+once, then runs the command after "--", if any, with its exit code.
+*/
+func runWait(args []string) int {
+	fs := flag.NewFlagSet("wait", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: utils wait [flags] [-- command args...]\n\nflags:\n")
+		fs.PrintDefaults()
+	}
+	var ports, reaches, names, pids stringList
+	fs.Var(&ports, "port", "wait for host:port to accept connections (repeatable)")
+	fs.Var(&reaches, "reach", "wait for network:address to answer: udp:host:port, unix:/path, tcp6:[fe80::1%eth0]:22 (repeatable)")
+	fs.Var(&names, "process", "wait for a process with this name (repeatable)")
+	fs.Var(&pids, "exit", "wait for the process with this PID to exit (repeatable)")
+	timeout := fs.Duration("timeout", 30*time.Second, "give up after this long, 0 waits forever")
+	strict := fs.Bool("strict", true, "don't run the command when a condition is not met")
+	quiet := fs.Bool("quiet", false, "don't print progress")
+	fs.Parse(args)
+
+	type condition struct {
+		name string
+		wait func(context.Context, ...WaitOption) error
+	}
+	var conditions []condition
+	for _, addr := range ports {
+		host, p, err := net.SplitHostPort(addr)
+		port, err2 := strconv.Atoi(p)
+		if err != nil || err2 != nil {
+			log.Printf("invalid -port %q, expected host:port", addr)
+			return 2
+		}
+		conditions = append(conditions, condition{"port " + addr, func(ctx context.Context, opts ...WaitOption) error {
+			return WaitForPort(ctx, host, port, opts...)
+		}})
+	}
+	for _, spec := range reaches {
+		c, err := parseReach(spec)
+		if err != nil {
+			log.Println(err)
+			return 2
+		}
+		conditions = append(conditions, condition{spec, func(ctx context.Context, opts ...WaitOption) error {
+			return WaitForReach(ctx, c, opts...)
+		}})
+	}
+	for _, name := range names {
+		name := name
+		conditions = append(conditions, condition{"process " + name, func(ctx context.Context, opts ...WaitOption) error {
+			_, err := WaitForProcess(ctx, name, opts...)
+			return err
+		}})
+	}
+	for _, s := range pids {
+		pid, err := strconv.Atoi(s)
+		if err != nil {
+			log.Printf("invalid -exit %q, expected a PID", s)
+			return 2
+		}
+		conditions = append(conditions, condition{"exit of " + s, func(ctx context.Context, opts ...WaitOption) error {
+			return WaitForExit(ctx, pid, opts...)
+		}})
+	}
+
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	failed := false
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range conditions {
+		wg.Add(1)
+		go func(c condition) {
+			defer wg.Done()
+			var opts []WaitOption
+			if !*quiet {
+				opts = append(opts, WithProgress(func(attempt int, elapsed time.Duration, err error) {
+					log.Printf("waiting for %s (%s): %v", c.name, elapsed.Round(time.Millisecond), err)
+				}))
+			}
+			err := c.wait(ctx, opts...)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed = true
+				log.Printf("%s: %v", c.name, err)
+			} else if !*quiet {
+				log.Printf("%s: ready after %s", c.name, time.Since(start).Round(time.Millisecond))
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	if failed && *strict {
+		return 1
+	}
+	if fs.NArg() == 0 {
+		if failed {
+			return 1
+		}
+		return 0
+	}
+	cmd := exec.Command(fs.Arg(0), fs.Args()[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode()
+		}
+		log.Println(err)
+		return 127
+	}
+	return 0
+}
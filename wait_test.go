@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWaitFor(t *testing.T) {
+	var delays []time.Duration
+	last := time.Now()
+	attempts := 0
+	err := waitFor(context.Background(), waitBackoff{Initial: 10 * time.Millisecond, Max: 40 * time.Millisecond}, func(context.Context) error {
+		now := time.Now()
+		if attempts > 0 {
+			delays = append(delays, now.Sub(last))
+		}
+		last = now
+		if attempts++; attempts < 5 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 10, 20, 40, 40
+	want := []time.Duration{10, 20, 40, 40}
+	for i, d := range delays {
+		if d < want[i]*time.Millisecond {
+			t.Errorf("delay %d: %s, want at least %dms", i, d, want[i])
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = waitFor(ctx, waitBackoff{}, func(context.Context) error { return errors.New("port closed") })
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "port closed") {
+		t.Errorf("got %v, want the deadline and the last reason", err)
+	}
+}
+
+func TestWaitForPort(t *testing.T) {
+	// nobody listens yet
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	progress := 0
+	opened := make(chan net.Listener, 1)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		l, _ := net.Listen("tcp", addr)
+		opened <- l
+	}()
+	defer func() {
+		if l := <-opened; l != nil {
+			l.Close()
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = WaitForPort(ctx, "127.0.0.1", port, WithBackoff(10*time.Millisecond, 20*time.Millisecond),
+		WithProgress(func(int, time.Duration, error) { progress++ }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress == 0 {
+		t.Error("no progress before the port opened")
+	}
+}
+
+func TestWaitForProcessAndExit(t *testing.T) {
+	// a process with a name nobody else has
+	name := "waittest" + filepath.Base(t.TempDir())
+	if len(name) > 15 {
+		name = name[:15]
+	}
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip(err)
+	}
+	link := filepath.Join(t.TempDir(), name)
+	if err := os.Symlink(sleep, link); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cmd := exec.Command(link, "0.3")
+	started := make(chan error, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		started <- cmd.Start()
+	}()
+	pid, err := WaitForProcess(ctx, name, WithBackoff(10*time.Millisecond, 0))
+	if err := <-started; err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid != cmd.Process.Pid {
+		t.Fatalf("pid %d, want %d", pid, cmd.Process.Pid)
+	}
+	// exited, and not reaped yet: a zombie is gone for WaitForExit
+	if err := WaitForExit(ctx, pid, WithBackoff(10*time.Millisecond, 0)); err != nil {
+		t.Fatal(err)
+	}
+	cmd.Wait()
+
+	short, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := WaitForExit(short, os.Getpid()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waiting for ourselves: %v", err)
+	}
+}